@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	hb "github.com/whyrusleeping/hellabot"
+)
+
+const (
+	rplWhoisAccount = "330"
+	rplEndOfWhois   = "318"
+)
+
+var (
+	accountTimeout = 10 * time.Second
+	accountTTL     = 10 * time.Minute
+)
+
+var accounts = NewAccountCache()
+
+// AccountCache resolves nicks to the services account they are logged in as
+// by issuing WHOIS queries. Answers are cached for accountTTL, or until the
+// nick changes or quits.
+type AccountCache struct {
+	mu      sync.Mutex
+	known   map[string]accountEntry
+	whois   map[string]string
+	pending map[string][]chan string
+}
+
+type accountEntry struct {
+	account string
+	at      time.Time
+}
+
+func NewAccountCache() *AccountCache {
+	return &AccountCache{
+		known:   make(map[string]accountEntry),
+		whois:   make(map[string]string),
+		pending: make(map[string][]chan string),
+	}
+}
+
+// Lookup returns the account nick is logged in as, or "" if it is not
+// logged in or the server did not answer in time.
+func (ac *AccountCache) Lookup(nick string) string {
+	key := strings.ToLower(nick)
+
+	ac.mu.Lock()
+	if e, ok := ac.known[key]; ok && time.Since(e.at) < accountTTL {
+		ac.mu.Unlock()
+		return e.account
+	}
+	ch := make(chan string, 1)
+	first := len(ac.pending[key]) == 0
+	ac.pending[key] = append(ac.pending[key], ch)
+	ac.mu.Unlock()
+
+	if first {
+		bot.Send("WHOIS " + nick)
+	}
+
+	select {
+	case account := <-ch:
+		return account
+	case <-time.After(accountTimeout):
+		ac.mu.Lock()
+		delete(ac.pending, key)
+		ac.mu.Unlock()
+		return ""
+	}
+}
+
+// Cached returns the account nick was last seen logged in as, without
+// asking the server.
+func (ac *AccountCache) Cached(nick string) string {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if e, ok := ac.known[strings.ToLower(nick)]; ok && time.Since(e.at) < accountTTL {
+		return e.account
+	}
+	return ""
+}
+
+// Forget drops any cached account for nick.
+func (ac *AccountCache) Forget(nick string) {
+	ac.mu.Lock()
+	delete(ac.known, strings.ToLower(nick))
+	ac.mu.Unlock()
+}
+
+func (ac *AccountCache) handle(mes *hb.Message) {
+	switch mes.Command {
+	case rplWhoisAccount:
+		// :server 330 <me> <nick> <account> :is logged in as
+		if len(mes.Params) < 3 {
+			return
+		}
+		ac.mu.Lock()
+		ac.whois[strings.ToLower(mes.Params[1])] = mes.Params[2]
+		ac.mu.Unlock()
+	case rplEndOfWhois:
+		// :server 318 <me> <nick> :End of /WHOIS list.
+		if len(mes.Params) < 2 {
+			return
+		}
+		key := strings.ToLower(mes.Params[1])
+
+		ac.mu.Lock()
+		account := ac.whois[key]
+		delete(ac.whois, key)
+		ac.known[key] = accountEntry{account: account, at: time.Now()}
+		waiting := ac.pending[key]
+		delete(ac.pending, key)
+		ac.mu.Unlock()
+
+		for _, ch := range waiting {
+			ch <- account
+		}
+	case "NICK", "QUIT":
+		ac.Forget(mes.From)
+	}
+}
+
+var accountTrigger = hb.Trigger{
+	Condition: func(irc *hb.Bot, mes *hb.Message) bool {
+		switch mes.Command {
+		case rplWhoisAccount, rplEndOfWhois, "NICK", "QUIT":
+			return true
+		}
+		return false
+	},
+	Action: func(irc *hb.Bot, mes *hb.Message) bool {
+		accounts.handle(mes)
+		// let nick changes and quits through to the other triggers
+		return mes.Command != "NICK" && mes.Command != "QUIT"
+	},
+}
+
+// senderOf returns who sent mes.
+func senderOf(mes *hb.Message) Sender {
+	if mes.Prefix == nil {
+		return Sender{Nick: mes.From}
+	}
+	return Sender{
+		Nick: mes.Prefix.Name,
+		User: mes.Prefix.User,
+		Host: mes.Prefix.Host,
+	}
+}
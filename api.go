@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	apiTransport = "api"
+	// minTokenLength keeps API tokens from being guessable.
+	minTokenLength = 16
+)
+
+// API serves pinning over HTTP to clients holding a token.
+type API struct {
+	cfg APIConfig
+	// channel is where inbound webhooks are announced.
+	channel string
+	// echo is the address API activity is repeated to, if any.
+	echo string
+}
+
+func NewAPI(cfg APIConfig, channel string) *API {
+	a := &API{cfg: cfg, channel: channel}
+	if cfg.Echo {
+		a.echo = channel
+	}
+	return a
+}
+
+// Name makes API a Transport, so that the messages the pinning functions
+// send about API requests end up in the IRC channel (with echo) or in the
+// log.
+func (a *API) Name() string {
+	return apiTransport
+}
+
+func (a *API) Msg(to, msg string) {
+	if a.echo == "" {
+		logger.Info("api reply", "actor", to, "msg", msg)
+		return
+	}
+	botMsg(a.echo, fmt.Sprintf("[api %s] %s", to, msg))
+}
+
+func (a *API) Notice(to, msg string) {
+	a.Msg(to, msg)
+}
+
+// Serve serves the API on ln until it fails.
+func (a *API) Serve(ln net.Listener) error {
+	srv := &http.Server{
+		Handler:           a.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return srv.Serve(ln)
+}
+
+// handler routes the API endpoints.
+func (a *API) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /pins", a.auth(cmdPin, a.pin))
+	mux.HandleFunc("DELETE /pins/{cid}", a.auth(cmdUnPin, a.unpin))
+	mux.HandleFunc("GET /pins/{cid}/status", a.auth(cmdStatus, a.status))
+	mux.HandleFunc("POST /pins/{cid}/recover", a.auth(cmdRecover, a.recoverPin))
+	mux.HandleFunc("GET /jobs", a.auth(cmdJobs, a.listJobs))
+	mux.HandleFunc("POST /hooks/{name}", a.hook)
+	return mux
+}
+
+type apiHandler func(w http.ResponseWriter, r *http.Request, from Sender)
+
+// auth checks the bearer token of a request and that its friend may run
+// cmd before calling h.
+func (a *API) auth(cmd string, h apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if shuttingDown.Load() {
+			apiError(w, http.StatusServiceUnavailable, ErrStopped)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		name := ""
+		if ok {
+			name = a.lookupToken(token)
+		}
+		if name == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			apiError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+			return
+		}
+
+		from := Sender{Nick: name, Friend: name}
+		if !friends.Can(from, cmd) {
+			apiError(w, http.StatusForbidden, fmt.Errorf("%s may not %s", name, cmd))
+			return
+		}
+		h(w, r, from)
+	}
+}
+
+// lookupToken returns the friend that token belongs to, or "".
+func (a *API) lookupToken(token string) string {
+	found := ""
+	for name, t := range a.cfg.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			found = name
+		}
+	}
+	return found
+}
+
+// run runs fn on the worker pool on behalf of from and waits for it.
+func (a *API) run(w http.ResponseWriter, from Sender, fn func()) bool {
+	done := make(chan struct{})
+	_, err := pool.Submit(from.Nick, func() {
+		defer close(done)
+		fn()
+	})
+	if err != nil {
+		apiError(w, http.StatusServiceUnavailable, err)
+		return false
+	}
+	<-done
+	return true
+}
+
+func (a *API) pin(w http.ResponseWriter, r *http.Request, from Sender) {
+	var req struct {
+		Cid     string  `json:"cid"`
+		Label   string  `json:"label"`
+		Options PinOpts `json:"options"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Cid == "" || req.Label == "" {
+		apiError(w, http.StatusBadRequest, errors.New("cid and label are required"))
+		return
+	}
+	if err := req.Options.Validate(); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := req.Options.Allowed(from); err != nil {
+		apiError(w, http.StatusForbidden, err)
+		return
+	}
+
+	a.submit(w, from, func() (Job, error) {
+		return PinLabeled(address(a, from.Nick), from, req.Cid, req.Label, req.Options)
+	})
+}
+
+func (a *API) unpin(w http.ResponseWriter, r *http.Request, from Sender) {
+	a.submit(w, from, func() (Job, error) {
+		return UnpinCluster(address(a, from.Nick), from, r.PathValue("cid"))
+	})
+}
+
+func (a *API) recoverPin(w http.ResponseWriter, r *http.Request, from Sender) {
+	a.submit(w, from, func() (Job, error) {
+		return RecoverCluster(address(a, from.Nick), from, r.PathValue("cid"))
+	})
+}
+
+// submit runs a cluster operation and replies with its job.
+func (a *API) submit(w http.ResponseWriter, from Sender, op func() (Job, error)) {
+	var j Job
+	var err error
+	if !a.run(w, from, func() { j, err = op() }) {
+		return
+	}
+	if err != nil {
+		apiError(w, http.StatusBadGateway, err)
+		return
+	}
+	apiReply(w, http.StatusAccepted, j)
+}
+
+func (a *API) status(w http.ResponseWriter, r *http.Request, from Sender) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	st, err := clusterStatus(ctx, r.PathValue("cid"))
+	if err != nil {
+		apiError(w, http.StatusBadGateway, err)
+		return
+	}
+	apiReply(w, http.StatusOK, st)
+}
+
+func (a *API) listJobs(w http.ResponseWriter, r *http.Request, from Sender) {
+	list := jobs.List()
+	if list == nil {
+		list = []Job{}
+	}
+	apiReply(w, http.StatusOK, list)
+}
+
+func apiReply(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, code int, err error) {
+	apiReply(w, code, map[string]string{"error": err.Error()})
+}
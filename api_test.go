@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAPIAuth(t *testing.T) {
+	withFriends(t, map[string]Friend{
+		"ci":     {Name: "ci", Role: "pinner"},
+		"reaper": {Name: "reaper", Role: "unpinner"},
+	})
+	oldJobs := jobs
+	jobs = NewJobManager(filepath.Join(t.TempDir(), "jobs.json"))
+	t.Cleanup(func() { jobs = oldJobs })
+
+	a := NewAPI(APIConfig{Tokens: map[string]string{
+		"ci":     "ci-token-0123456789",
+		"reaper": "reaper-token-0123456789",
+		// has a token, but is not a friend
+		"mallory": "mallory-token-0123456789",
+	}}, "#pinbot")
+	srv := httptest.NewServer(a.handler())
+	defer srv.Close()
+
+	tests := []struct {
+		method, path string
+		auth         string
+		code         int
+		err          string
+	}{
+		{"GET", "/jobs", "", http.StatusUnauthorized, "missing or invalid token"},
+		{"GET", "/jobs", "ci-token-0123456789", http.StatusUnauthorized, "missing or invalid token"},
+		{"GET", "/jobs", "Bearer ci-token", http.StatusUnauthorized, "missing or invalid token"},
+		{"GET", "/jobs", "Bearer nobody-token-0123456789", http.StatusUnauthorized, "missing or invalid token"},
+		// everyone may list jobs
+		{"GET", "/jobs", "Bearer ci-token-0123456789", http.StatusOK, ""},
+		{"GET", "/jobs", "Bearer mallory-token-0123456789", http.StatusOK, ""},
+		{"DELETE", "/pins/" + testCidV0, "Bearer ci-token-0123456789", http.StatusForbidden, "ci may not unpin"},
+		{"POST", "/pins", "Bearer reaper-token-0123456789", http.StatusForbidden, "reaper may not pin"},
+		{"POST", "/pins/" + testCidV0 + "/recover", "Bearer mallory-token-0123456789", http.StatusForbidden, "mallory may not recover"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader("{}"))
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Error string `json:"error"`
+		}
+		if tt.code != http.StatusOK {
+			json.NewDecoder(resp.Body).Decode(&body)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.code || body.Error != tt.err {
+			t.Errorf("%s %s with %q = %d %q, want %d %q", tt.method, tt.path, tt.auth, resp.StatusCode, body.Error, tt.code, tt.err)
+		}
+		if got := resp.Header.Get("WWW-Authenticate"); (tt.code == http.StatusUnauthorized) != (got == "Bearer") {
+			t.Errorf("%s %s with %q: WWW-Authenticate = %q", tt.method, tt.path, tt.auth, got)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// handlers maps each command to the function that runs it. args holds the
+// command name followed by its arguments.
+var handlers = map[string]func(c Command, args []string){
+	cmdBotsnack:    handleBotsnack,
+	cmdPinLegacy:   handleLegacyPin,
+	cmdUnpinLegacy: handleLegacyUnpin,
+	cmdPin:         handlePin,
+	cmdUnPin:       handleUnpin,
+	cmdStatus:      handleStatus,
+	cmdRecover:     handleRecover,
+	cmdOngoing:     handleOngoing,
+	cmdMore:        handleMore,
+	cmdExtend:      handleExtend,
+	cmdExpiring:    handleExpiring,
+	cmdHistory:     handleHistory,
+	cmdRollback:    handleRollback,
+	cmdJobs:        handleJobs,
+	cmdCancel:      handleCancel,
+	cmdPins:        handlePins,
+	cmdWhois:       handleWhois,
+	cmdReload:      handleReload,
+	cmdFriends:     handleFriends,
+	cmdBefriend:    handleBefriend,
+	cmdShun:        handleShun,
+}
+
+// dispatch runs fn on the worker pool on behalf of the sender of c, and
+// tells them if it has to wait for its turn.
+func dispatch(c Command, fn func()) {
+	tc, tracked := c.(*trackedCommand)
+	if tracked {
+		tc.dispatched = true
+		run := fn
+		fn = func() {
+			run()
+			tc.done()
+		}
+	}
+	pos, err := pool.Submit(c.Sender().Nick, fn)
+	if err != nil {
+		if tracked {
+			tc.dispatched = false
+			tc.dispatch = "busy"
+		}
+		reply(c, "sorry, I'm too busy right now: "+err.Error())
+		return
+	}
+	if pos > 0 {
+		reply(c, fmt.Sprintf("queued, position %d", pos))
+	}
+}
+
+func handleBotsnack(c Command, args []string) {
+	reply(c, "om nom nom")
+}
+
+func handleLegacyPin(c Command, args []string) {
+	if len(args) < 3 {
+		usage(c, "usage: !pin <hash> <label>")
+		return
+	}
+	dispatch(c, func() {
+		Pin(actorOf(c), c.Sender(), args[1], strings.Join(args[2:], " "))
+	})
+}
+
+func handleLegacyUnpin(c Command, args []string) {
+	if len(args) == 1 {
+		usage(c, "what do you want me to unpin?")
+		return
+	}
+	dispatch(c, func() {
+		Unpin(actorOf(c), c.Sender(), args[1])
+	})
+}
+
+func handlePin(c Command, args []string) {
+	path, label, opts, err := parsePinArgs(args[1:], time.Now())
+	if err != nil {
+		reply(c, err.Error())
+		usage(c, "usage: !pin <hash> <label> | <label> <hash> [--rmin <n>] [--rmax <n>] [--allocations <peer>,...] [--expire <duration>|<date>] [--ttl <duration>] [--meta <key>=<value> ...]")
+		return
+	}
+	if err := opts.Allowed(c.Sender()); err != nil {
+		refuse(c, err.Error())
+		return
+	}
+	if len(opts.Metadata) > 0 {
+		reply(c, "pinning with metadata "+formatMeta(opts.Metadata))
+	}
+	dispatch(c, func() {
+		if _, err := PinLabeled(actorOf(c), c.Sender(), path, label, opts); err != nil {
+			setOutcome(c, "failed")
+		}
+	})
+}
+
+func handleUnpin(c Command, args []string) {
+	if len(args) == 1 {
+		usage(c, "what do you want me to unpin from cluster?")
+		return
+	}
+	if _, ok := labels.Current(args[1]); ok && isLabel(args[1]) {
+		if labels.IsUnpinned(args[1]) {
+			reply(c, fmt.Sprintf("%s is already unpinned", args[1]))
+			return
+		}
+		dispatch(c, func() {
+			UnpinLabel(actorOf(c), c.Sender(), args[1])
+		})
+		return
+	}
+	dispatch(c, func() {
+		if _, err := UnpinCluster(actorOf(c), c.Sender(), args[1]); err != nil {
+			setOutcome(c, "failed")
+		}
+	})
+}
+
+func handleStatus(c Command, args []string) {
+	args, full := cutFlag(args, "--full")
+	if len(args) == 1 {
+		usage(c, "usage: !status <hash> [--full]")
+		return
+	}
+	dispatch(c, func() {
+		StatusCluster(actorOf(c), args[1], full)
+	})
+}
+
+func handleRecover(c Command, args []string) {
+	if len(args) == 1 {
+		usage(c, "usage: !recover <hash>")
+		return
+	}
+	dispatch(c, func() {
+		if _, err := RecoverCluster(actorOf(c), c.Sender(), args[1]); err != nil {
+			setOutcome(c, "failed")
+		}
+	})
+}
+
+func handleOngoing(c Command, args []string) {
+	_, full := cutFlag(args, "--full")
+	dispatch(c, func() {
+		StatusAllCluster(actorOf(c), api.TrackerStatusError|api.TrackerStatusPinning|api.TrackerStatusQueued|api.TrackerStatusUnpinning, full)
+	})
+}
+
+func handleMore(c Command, args []string) {
+	pager.More(actorOf(c))
+}
+
+func handleExtend(c Command, args []string) {
+	if len(args) != 3 {
+		usage(c, "usage: !extend <hash> <duration>")
+		return
+	}
+	d, err := parseDuration(args[2])
+	if err != nil {
+		usage(c, err.Error())
+		return
+	}
+	ExtendCmd(actorOf(c), args[1], d)
+}
+
+func handleExpiring(c Command, args []string) {
+	ExpiringCmd(actorOf(c))
+}
+
+func handleHistory(c Command, args []string) {
+	if len(args) != 2 {
+		usage(c, "usage: !history <label>")
+		return
+	}
+	HistoryCmd(actorOf(c), args[1])
+}
+
+func handleRollback(c Command, args []string) {
+	unpin := len(args) == 3 && args[2] == "unpin"
+	if len(args) != 2 && !unpin {
+		usage(c, "usage: !rollback <label> [unpin]")
+		return
+	}
+	if unpin && !friends.Can(c.Sender(), cmdUnPin) {
+		refuse(c, "you may not unpin, leave out unpin to keep the newer version pinned")
+		return
+	}
+	dispatch(c, func() {
+		RollbackLabel(actorOf(c), c.Sender(), args[1], unpin)
+	})
+}
+
+func handleJobs(c Command, args []string) {
+	list := jobs.List()
+	if len(list) == 0 {
+		reply(c, "no jobs in flight")
+		return
+	}
+	for _, j := range list {
+		botMsg(actorOf(c), j.String())
+	}
+}
+
+func handleCancel(c Command, args []string) {
+	unpin := len(args) == 3 && args[2] == "unpin"
+	if len(args) != 2 && !unpin {
+		usage(c, "usage: !cancel <job id> [unpin]")
+		return
+	}
+	id, err := strconv.Atoi(args[1])
+	if err != nil {
+		usage(c, "usage: !cancel <job id> [unpin]")
+		return
+	}
+	if unpin && !friends.Can(c.Sender(), cmdUnPin) {
+		refuse(c, "you may not unpin, leave out unpin to only stop watching the job")
+		return
+	}
+
+	CancelJob(actorOf(c), c.Sender(), id, unpin)
+}
+
+func handlePins(c Command, args []string) {
+	if len(args) == 1 {
+		usage(c, "usage: !pins <label> | by <nick> | since <date>")
+		return
+	}
+	dispatch(c, func() {
+		SearchPinsCmd(actorOf(c), args[1:])
+	})
+}
+
+func handleWhois(c Command, args []string) {
+	if len(args) != 2 {
+		usage(c, "usage: !whois <hash>")
+		return
+	}
+	dispatch(c, func() {
+		WhoisCmd(actorOf(c), args[1])
+	})
+}
+
+func handleReload(c Command, args []string) {
+	ReloadCmd(actorOf(c))
+}
+
+func handleFriends(c Command, args []string) {
+	names := friends.Names()
+	if offload(len(names)) {
+		url := reports.Add("pinbot's friends", names)
+		botNotice(address(c.Transport(), c.Private()), fmt.Sprintf("I have %d friends: %s", len(names), url))
+		return
+	}
+
+	out := "my friends are: "
+	for _, n := range names {
+		out += n + " "
+	}
+	botNotice(address(c.Transport(), c.Private()), out)
+}
+
+func handleBefriend(c Command, args []string) {
+	if len(args) < 3 {
+		usage(c, prefix+cmdBefriend+" <name> <role> [account=<account>] [host=<mask>] [matrix=<@user:server>] [insecure] [<duration>|<date>]")
+		return
+	}
+
+	// a bare duration or date limits how long the grant lasts
+	args = args[1:]
+	for i := 2; i < len(args); i++ {
+		if t, err := parseExpiry(args[i], time.Now()); err == nil {
+			args[i] = "expires=" + t.UTC().Format(time.RFC3339)
+		}
+	}
+
+	f, err := parseFriend(args, currentRoles())
+	if err == nil {
+		err = friends.AddFriend(f)
+	}
+	if err != nil {
+		fail(c, "failed to befriend: "+err.Error())
+		return
+	}
+	msg := "Hey " + f.Name + ", let's be friends! You are now " + f.Role
+	if !f.Expires.IsZero() {
+		msg += " until " + f.Expires.UTC().Format(time.RFC1123)
+	}
+	reply(c, msg)
+}
+
+func handleShun(c Command, args []string) {
+	if len(args) != 2 {
+		usage(c, "who do you want me to shun?")
+		return
+	}
+
+	name := args[1]
+	if err := friends.RmFriend(name); err != nil {
+		fail(c, "failed to shun: "+err.Error())
+		return
+	}
+	reply(c, "shun "+name+" the non believer! Shuuuuuuuun")
+}
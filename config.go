@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var configFile = "pinbot.json"
+
+// Config holds all of pinbot's settings. It is read from a JSON file, and
+// command-line flags override individual settings.
+type Config struct {
+	Name    string `json:"name"`
+	Server  string `json:"server"`
+	Channel string `json:"channel"`
+	Prefix  string `json:"prefix"`
+	Gateway string `json:"gateway"`
+
+	Cluster ClusterConfig `json:"cluster"`
+	// Hosts are IPFS API addresses used for legacy pins when there are no
+	// cluster peers.
+	Hosts []string `json:"hosts"`
+
+	// Matrix, when set, also serves commands in Matrix rooms.
+	Matrix *MatrixConfig `json:"matrix,omitempty"`
+	// API, when set, serves the HTTP API.
+	API *APIConfig `json:"api,omitempty"`
+	// Metrics is the address to serve Prometheus metrics on, if any.
+	Metrics string `json:"metrics,omitempty"`
+	// Reports, when set, serves replies too long for chat as web pages.
+	Reports *ReportsConfig `json:"reports,omitempty"`
+	// Webhooks are told about pins as they progress.
+	Webhooks []WebhookConfig `json:"webhooks,omitempty"`
+
+	Log   LogConfig   `json:"log"`
+	Files FilesConfig `json:"files"`
+	// Roles replaces the roles file when set.
+	Roles Roles `json:"roles,omitempty"`
+
+	// Flood limits how fast messages are sent to IRC.
+	Flood FloodConfig `json:"flood"`
+
+	Workers    int      `json:"workers"`
+	Queue      int      `json:"queue"`
+	JobTimeout Duration `json:"job_timeout"`
+
+	// warnings are problems that did not stop the config from loading.
+	// They are logged once logging has been set up.
+	warnings []error
+}
+
+type ClusterConfig struct {
+	Username string       `json:"username"`
+	Password string       `json:"password"`
+	Retries  int          `json:"retries"`
+	Peers    []PeerConfig `json:"peers"`
+}
+
+// PeerConfig describes how to reach the REST API of one cluster peer.
+// Username and Password override the cluster-wide credentials.
+type PeerConfig struct {
+	Addr         string `json:"addr"`
+	SSL          bool   `json:"ssl"`
+	NoVerifyCert bool   `json:"no_verify_cert"`
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+}
+
+// MatrixConfig describes the Matrix account pinbot logs in as and the rooms
+// it serves.
+type MatrixConfig struct {
+	Homeserver  string   `json:"homeserver"`
+	UserID      string   `json:"user_id"`
+	AccessToken string   `json:"access_token"`
+	Rooms       []string `json:"rooms"`
+}
+
+// APIConfig configures the HTTP API. Tokens maps friend names to the API
+// token that authenticates them.
+type APIConfig struct {
+	Listen string            `json:"listen"`
+	Tokens map[string]string `json:"tokens"`
+	// Echo repeats API activity in the IRC channel.
+	Echo bool `json:"echo"`
+	// Inbound webhooks pin what they are sent.
+	Inbound []InboundConfig `json:"inbound,omitempty"`
+}
+
+// InboundConfig is a webhook served at /hooks/<name> that accepts payloads
+// signed with Secret. Label, when set, replaces the label in the payload.
+type InboundConfig struct {
+	Name   string `json:"name"`
+	Secret string `json:"secret"`
+	Label  string `json:"label,omitempty"`
+}
+
+// ReportsConfig configures the report server. URL is the address the
+// reports are linked at, by default http://<listen>. Replies longer than
+// Threshold lines become reports, which are kept for Expiry.
+type ReportsConfig struct {
+	Listen    string   `json:"listen"`
+	URL       string   `json:"url,omitempty"`
+	Threshold int      `json:"threshold,omitempty"`
+	Expiry    Duration `json:"expiry"`
+}
+
+// WebhookConfig is a URL that gets the events it lists, or all of them,
+// signed with Secret.
+type WebhookConfig struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events,omitempty"`
+}
+
+// FloodConfig lets Burst messages go out at once, and one more every
+// Interval after that.
+type FloodConfig struct {
+	Burst    int      `json:"burst"`
+	Interval Duration `json:"interval"`
+}
+
+type FilesConfig struct {
+	Friends    string `json:"friends"`
+	Roles      string `json:"roles"`
+	Journal    string `json:"journal"`
+	LegacyPins string `json:"legacy_pins"`
+	Jobs       string `json:"jobs"`
+	Outbox     string `json:"outbox"`
+	Schedule   string `json:"schedule"`
+	Labels     string `json:"labels"`
+}
+
+// Duration is a time.Duration written as a string such as "1h" or "7d".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1h\"")
+	}
+	v, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// DefaultConfig returns the settings used when neither the config file nor
+// a flag says otherwise.
+func DefaultConfig() *Config {
+	return &Config{
+		Name:    "pinbot-test",
+		Server:  "irc.freenode.net:6667",
+		Channel: "#pinbot-test",
+		Prefix:  "!",
+		Gateway: "https://ipfs.io",
+		Cluster: ClusterConfig{
+			Retries: 5,
+		},
+		Log: LogConfig{
+			LogOutput: LogOutput{Level: "info", Format: "logfmt", Output: "stdout"},
+		},
+		Files: FilesConfig{
+			Friends:    "friends",
+			Roles:      "roles",
+			Journal:    "pins.jsonl",
+			LegacyPins: "pins.log",
+			Jobs:       "jobs.json",
+			Outbox:     "outbox.json",
+			Schedule:   "expiry.json",
+			Labels:     "labels.json",
+		},
+		Flood: FloodConfig{
+			Burst:    5,
+			Interval: Duration{2 * time.Second},
+		},
+		Workers:    4,
+		Queue:      50,
+		JobTimeout: Duration{time.Hour},
+	}
+}
+
+// LoadConfig reads the config file on top of the defaults. When the file
+// does not exist, cluster peers and hosts are read from the clusterpeers and
+// hosts files instead, as older versions did.
+func LoadConfig(file string) (*Config, error) {
+	cfg := DefaultConfig()
+
+	buf, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		cfg.Cluster.Peers, err = legacyPeers("clusterpeers")
+		if err != nil {
+			cfg.warnings = append(cfg.warnings, err)
+		}
+		if len(cfg.Cluster.Peers) == 0 {
+			cfg.Hosts, err = loadHosts("hosts")
+			if err != nil {
+				cfg.warnings = append(cfg.warnings, err)
+			}
+		}
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, describeJSONError(buf, err))
+	}
+	return cfg, nil
+}
+
+// legacyPeers reads a clusterpeers file, one "<multiaddr>[,ssl|,sslnoverify]"
+// per line. The error is loadHosts'.
+func legacyPeers(file string) ([]PeerConfig, error) {
+	var peers []PeerConfig
+	lines, err := loadHosts(file)
+	for _, line := range lines {
+		spl := strings.Split(line, ",")
+		p := PeerConfig{Addr: spl[0]}
+		if len(spl) > 1 && spl[1] == "ssl" {
+			p.SSL = true
+		}
+		if len(spl) > 1 && spl[1] == "sslnoverify" {
+			p.SSL = true
+			p.NoVerifyCert = true
+		}
+		peers = append(peers, p)
+	}
+	return peers, err
+}
+
+// loadHosts reads a file of addresses, one per line. When the file cannot
+// be opened it returns the local IPFS API along with the error, so that the
+// caller can carry on and report it once logging is set up.
+func loadHosts(file string) ([]string, error) {
+	fi, err := os.Open(file)
+	if err != nil {
+		return []string{"/ip4/127.0.0.1/tcp/5001"}, fmt.Errorf("failed to open hosts file, defaulting to localhost:5001: %s", err)
+	}
+	defer fi.Close()
+
+	var hosts []string
+	scan := bufio.NewScanner(fi)
+	for scan.Scan() {
+		hosts = append(hosts, scan.Text())
+	}
+	return hosts, scan.Err()
+}
+
+// describeJSONError adds the line and column to JSON decoding errors that
+// carry an offset.
+func describeJSONError(buf []byte, err error) string {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+		err = fmt.Errorf("%s: cannot use %s as %s", typeErr.Field, typeErr.Value, typeErr.Type)
+	default:
+		return err.Error()
+	}
+
+	before := buf[:min(int(offset), len(buf))]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Sprintf("line %d, column %d: %s", line, col, err)
+}
+
+// Validate checks every setting and returns one error per problem found.
+func (cfg *Config) Validate() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.Name != "" && !strings.ContainsAny(cfg.Name, " ,*?!@"), "name: invalid nickname %q", cfg.Name)
+	_, _, err := net.SplitHostPort(cfg.Server)
+	check(err == nil, "server: %q is not host:port", cfg.Server)
+	check(strings.HasPrefix(cfg.Channel, "#") || strings.HasPrefix(cfg.Channel, "&"), "channel: %q does not start with # or &", cfg.Channel)
+	check(cfg.Prefix != "" && !strings.Contains(cfg.Prefix, " "), "prefix: must be non-empty and have no spaces")
+	u, err := url.Parse(cfg.Gateway)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "gateway: %q is not an http(s) URL", cfg.Gateway)
+
+	check(cfg.Cluster.Retries >= 0, "cluster.retries: must not be negative")
+	for i, p := range cfg.Cluster.Peers {
+		_, err := ma.NewMultiaddr(p.Addr)
+		check(err == nil, "cluster.peers[%d].addr: invalid multiaddr %q: %v", i, p.Addr, err)
+		check(!p.NoVerifyCert || p.SSL, "cluster.peers[%d]: no_verify_cert requires ssl", i)
+	}
+	for i, h := range cfg.Hosts {
+		check(h != "", "hosts[%d]: empty address", i)
+	}
+	check(len(cfg.Cluster.Peers) > 0 || len(cfg.Hosts) > 0, "cluster.peers: need at least one cluster peer or host")
+
+	if m := cfg.Matrix; m != nil {
+		u, err := url.Parse(m.Homeserver)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "matrix.homeserver: %q is not an http(s) URL", m.Homeserver)
+		check(validMatrixID(m.UserID), "matrix.user_id: invalid Matrix user ID %q", m.UserID)
+		check(m.AccessToken != "", "matrix.access_token: must be set")
+		check(len(m.Rooms) > 0, "matrix.rooms: need at least one room")
+		for i, room := range m.Rooms {
+			check(strings.HasPrefix(room, "!") || strings.HasPrefix(room, "#"), "matrix.rooms[%d]: %q is not a room ID or alias", i, room)
+		}
+	}
+
+	if a := cfg.API; a != nil {
+		_, _, err := net.SplitHostPort(a.Listen)
+		check(err == nil, "api.listen: %q is not host:port", a.Listen)
+		seen := make(map[string]string)
+		for _, name := range slices.Sorted(maps.Keys(a.Tokens)) {
+			token := a.Tokens[name]
+			check(len(token) >= minTokenLength, "api.tokens.%s: token must be at least %d characters", name, minTokenLength)
+			if other, ok := seen[token]; ok {
+				check(false, "api.tokens.%s: same token as %s", name, other)
+			}
+			seen[token] = name
+		}
+
+		names := make(map[string]bool)
+		for i, h := range a.Inbound {
+			check(h.Name != "" && !strings.ContainsAny(h.Name, "/?#% "), "api.inbound[%d].name: invalid name %q", i, h.Name)
+			check(!names[h.Name], "api.inbound[%d].name: duplicate name %q", i, h.Name)
+			check(h.Secret != "", "api.inbound[%d].secret: must be set", i)
+			names[h.Name] = true
+		}
+	}
+
+	if cfg.Metrics != "" {
+		_, _, err := net.SplitHostPort(cfg.Metrics)
+		check(err == nil, "metrics: %q is not host:port", cfg.Metrics)
+	}
+
+	if rc := cfg.Reports; rc != nil {
+		_, _, err := net.SplitHostPort(rc.Listen)
+		check(err == nil, "reports.listen: %q is not host:port", rc.Listen)
+		if rc.URL != "" {
+			u, err := url.Parse(rc.URL)
+			check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "reports.url: %q is not an http(s) URL", rc.URL)
+		}
+		check(rc.Threshold >= 0, "reports.threshold: must not be negative")
+		check(rc.Expiry.Duration >= 0, "reports.expiry: must not be negative")
+	}
+
+	for i, w := range cfg.Webhooks {
+		u, err := url.Parse(w.URL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "webhooks[%d].url: %q is not an http(s) URL", i, w.URL)
+		check(w.Secret != "", "webhooks[%d].secret: must be set", i)
+		for _, ev := range w.Events {
+			check(slices.Contains(webhookEvents, ev), "webhooks[%d].events: unknown event %q", i, ev)
+		}
+	}
+
+	errs = append(errs, cfg.Log.validate()...)
+
+	check(cfg.Files.Friends != "", "files.friends: must be set")
+	check(cfg.Files.Roles != "", "files.roles: must be set")
+	check(cfg.Files.Journal != "", "files.journal: must be set")
+	check(cfg.Files.Jobs != "", "files.jobs: must be set")
+	check(cfg.Files.Outbox != "", "files.outbox: must be set")
+	check(cfg.Files.Schedule != "", "files.schedule: must be set")
+	check(cfg.Files.Labels != "", "files.labels: must be set")
+
+	names := make([]string, 0, len(cfg.Roles))
+	for role := range cfg.Roles {
+		names = append(names, role)
+	}
+	sort.Strings(names)
+	for _, role := range names {
+		cmds := cfg.Roles[role]
+		check(len(cmds) > 0, "roles.%s: grants no commands", role)
+		for _, c := range cmds {
+			check(validCommand(c), "roles.%s: unknown command %q", role, c)
+		}
+	}
+
+	check(cfg.Flood.Burst > 0, "flood.burst: must be at least 1")
+	check(cfg.Flood.Interval.Duration > 0, "flood.interval: must be positive")
+
+	check(cfg.Workers > 0, "workers: must be at least 1")
+	check(cfg.Queue >= 0, "queue: must not be negative")
+	check(cfg.JobTimeout.Duration > 0, "job_timeout: must be positive")
+	return errs
+}
+
+// checkConfig implements the check-config subcommand. It returns the exit
+// code.
+func checkConfig(file string) int {
+	if _, err := os.Stat(file); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	cfg, err := LoadConfig(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	errs := cfg.Validate()
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Printf("%s: ok\n", file)
+	return 0
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		json string
+		err  string
+	}{
+		{`{"name": "pinbot", "workers": 8}`, ""},
+		{"{\n  \"name\": \"pinbot\",\n  \"workers\": \"eight\"\n}", "line 3, column 21: workers: cannot use string as int"},
+		{"{\n  \"name\": \"pinbot\"\n  \"workers\": 8\n}", "line 3, column 4: invalid character"},
+		{`{"nmae": "pinbot"}`, `unknown field "nmae"`},
+		{`{"job_timeout": "soon"}`, "invalid duration: soon"},
+	}
+	for i, tt := range tests {
+		file := filepath.Join(dir, "pinbot.json")
+		os.WriteFile(file, []byte(tt.json), 0660)
+		cfg, err := LoadConfig(file)
+		if tt.err == "" {
+			if err != nil || cfg.Name != "pinbot" || cfg.Workers != 8 || cfg.Queue != 50 {
+				t.Errorf("%d: LoadConfig = %+v, %v, want the file on top of the defaults", i, cfg, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), file+": ") || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%d: LoadConfig error = %v, want %q", i, err, tt.err)
+		}
+	}
+}
+
+func TestLoadConfigLegacy(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	// without a clusterpeers file, the local IPFS API is used, and the
+	// missing file is reported once logging is set up
+	cfg, err := LoadConfig("pinbot.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.Cluster.Peers, []PeerConfig{{Addr: "/ip4/127.0.0.1/tcp/5001"}}) {
+		t.Errorf("peers %+v, want only the local IPFS API", cfg.Cluster.Peers)
+	}
+	if len(cfg.warnings) != 1 || !strings.Contains(cfg.warnings[0].Error(), "clusterpeers") {
+		t.Errorf("warnings %q, want one about clusterpeers", cfg.warnings)
+	}
+
+	os.WriteFile("clusterpeers", []byte("/dns4/one/tcp/9094\n/dns4/two/tcp/9094,ssl\n/dns4/three/tcp/9094,sslnoverify\n"), 0660)
+	cfg, err = LoadConfig("pinbot.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PeerConfig{
+		{Addr: "/dns4/one/tcp/9094"},
+		{Addr: "/dns4/two/tcp/9094", SSL: true},
+		{Addr: "/dns4/three/tcp/9094", SSL: true, NoVerifyCert: true},
+	}
+	if !reflect.DeepEqual(cfg.Cluster.Peers, want) || cfg.Hosts != nil || cfg.warnings != nil {
+		t.Errorf("peers %+v, hosts %q, warnings %q, want the clusterpeers file", cfg.Cluster.Peers, cfg.Hosts, cfg.warnings)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		change func(*Config)
+		errs   []string
+	}{
+		{func(cfg *Config) {}, nil},
+		{func(cfg *Config) { cfg.Name = "pin bot" }, []string{`name: invalid nickname "pin bot"`}},
+		{func(cfg *Config) { cfg.Server = "irc.example.org" }, []string{`server: "irc.example.org" is not host:port`}},
+		{func(cfg *Config) { cfg.Channel = "pinbot" }, []string{`channel: "pinbot" does not start with # or &`}},
+		{func(cfg *Config) { cfg.Gateway = "ipfs.io" }, []string{`gateway: "ipfs.io" is not an http(s) URL`}},
+		{func(cfg *Config) { cfg.Hosts = nil }, []string{"cluster.peers: need at least one cluster peer or host"}},
+		{func(cfg *Config) {
+			cfg.Cluster.Peers = []PeerConfig{{Addr: "/dns4/one/tcp/9094", NoVerifyCert: true}}
+		}, []string{"cluster.peers[0]: no_verify_cert requires ssl"}},
+		{func(cfg *Config) { cfg.API = &APIConfig{Listen: ":8080", Tokens: map[string]string{"ci": "short"}} },
+			[]string{"api.tokens.ci: token must be at least"}},
+		{func(cfg *Config) { cfg.Roles = Roles{"pinner": {"pin", "frobnicate"}, "nobody": {}} },
+			[]string{"roles.nobody: grants no commands", `roles.pinner: unknown command "frobnicate"`}},
+		{func(cfg *Config) {
+			cfg.Workers = 0
+			cfg.Files.Journal = ""
+			cfg.Flood.Interval = Duration{-time.Second}
+		}, []string{"files.journal: must be set", "flood.interval: must be positive", "workers: must be at least 1"}},
+	}
+	for i, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Hosts = []string{"/ip4/127.0.0.1/tcp/5001"}
+		tt.change(cfg)
+		errs := cfg.Validate()
+		if len(errs) != len(tt.errs) {
+			t.Errorf("%d: Validate = %q, want %q", i, errs, tt.errs)
+			continue
+		}
+		for j, err := range errs {
+			if !strings.HasPrefix(err.Error(), tt.errs[j]) {
+				t.Errorf("%d: error %d = %q, want %q", i, j, err, tt.errs[j])
+			}
+		}
+	}
+}
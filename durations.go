@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var day = 24 * time.Hour
+
+// parseDuration is like time.ParseDuration but also understands whole days
+// ("7d") and weeks ("2w").
+func parseDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": day, "w": 7 * day} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			i, err := strconv.Atoi(n)
+			if err != nil || i <= 0 {
+				return 0, fmt.Errorf("invalid duration: %s", s)
+			}
+			return time.Duration(i) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+	return d, nil
+}
+
+// parseExpiry turns a duration from now, a date (2006-01-02) or an RFC3339
+// time into an absolute point in time, which must lie after now.
+func parseExpiry(s string, now time.Time) (time.Time, error) {
+	var t time.Time
+	if d, err := parseDuration(s); err == nil {
+		t = now.Add(d)
+	} else if t, err = time.Parse(time.DateOnly, s); err != nil {
+		if t, err = time.Parse(time.RFC3339, s); err != nil {
+			return time.Time{}, fmt.Errorf("invalid duration or date: %s", s)
+		}
+	}
+
+	if !t.After(now) {
+		return time.Time{}, fmt.Errorf("%s is in the past", s)
+	}
+	return t, nil
+}
+
+// formatDuration renders d to the minute in days, hours and minutes, such
+// as "14d", "1d12h" or "3h5m". Minutes are left out beyond a day.
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days, rest := d/day, d%day
+	out := ""
+	if days > 0 {
+		out = fmt.Sprintf("%dd", days)
+	}
+	if h := rest / time.Hour; h > 0 {
+		out += fmt.Sprintf("%dh", h)
+	}
+	if m := rest % time.Hour / time.Minute; m > 0 && days == 0 {
+		out += fmt.Sprintf("%dm", m)
+	}
+	if out == "" {
+		return "0m"
+	}
+	return out
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		s    string
+		want time.Duration
+	}{
+		{"90m", 90 * time.Minute},
+		{"1h30m", 90 * time.Minute},
+		{"7d", 7 * day},
+		{"2w", 14 * day},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.s)
+		if err != nil || got != tt.want {
+			t.Errorf("parseDuration(%q) = %s, %v, want %s", tt.s, got, err, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "d", "0d", "-1d", "1.5d", "0s", "-1h", "soon"} {
+		if got, err := parseDuration(s); err == nil {
+			t.Errorf("parseDuration(%q) = %s, want an error", s, got)
+		}
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		s    string
+		want time.Time
+	}{
+		{"30d", now.Add(30 * day)},
+		{"1h", now.Add(time.Hour)},
+		{"2026-11-01", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"2026-10-18T13:00:00Z", time.Date(2026, 10, 18, 13, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseExpiry(tt.s, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseExpiry(%q) = %s, %v, want %s", tt.s, got, err, tt.want)
+		}
+	}
+
+	for _, s := range []string{"2026-10-18", "2026-01-01", "2026-10-18T11:00:00Z", "tomorrow", "18/10/2026"} {
+		if got, err := parseExpiry(s, now); err == nil {
+			t.Errorf("parseExpiry(%q) = %s, want an error", s, got)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0m"},
+		{20 * time.Second, "0m"},
+		{3*time.Hour + 5*time.Minute, "3h5m"},
+		{36 * time.Hour, "1d12h"},
+		{14 * day, "14d"},
+		{day + 30*time.Minute, "1d"},
+	}
+	for _, tt := range tests {
+		if got := formatDuration(tt.d); got != tt.want {
+			t.Errorf("formatDuration(%s) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+var (
+	// expiryWarning is how long before a pin expires its requester is
+	// warned.
+	expiryWarning = day
+	// expiryCheck is how often the schedule is checked.
+	expiryCheck = time.Minute
+)
+
+// expirySender is who automatic unpins are journaled as.
+var expirySender = Sender{Nick: "expiry"}
+
+// Expiry is a cluster pin that pinbot unpins once its time to live is up.
+type Expiry struct {
+	Cid     string    `json:"cid"`
+	Label   string    `json:"label,omitempty"`
+	Nick    string    `json:"nick"`
+	Actor   string    `json:"actor"`
+	Expires time.Time `json:"expires"`
+	Warned  bool      `json:"warned,omitempty"`
+	// unpinning is set while the unpin is on its way to the cluster. It
+	// is not saved, so that an unpin cut short by a restart is retried.
+	unpinning bool
+}
+
+// String describes x on a single line.
+func (x Expiry) String() string {
+	out := fmt.Sprintf("%s (in %s) %s", x.Expires.UTC().Format("2006-01-02 15:04"),
+		formatDuration(time.Until(x.Expires)), x.Cid)
+	if x.Label != "" {
+		out += fmt.Sprintf(" %q", x.Label)
+	}
+	return out + " by " + x.Nick
+}
+
+// Schedule holds the pins due to expire, keyed by cidKey so that either
+// form of a CID finds its expiry. It is written to its file on every
+// change, as an expiry that is forgotten never happens.
+type Schedule struct {
+	mu    sync.Mutex
+	file  string
+	items map[string]*Expiry
+}
+
+var schedule = NewSchedule("expiry.json")
+
+func NewSchedule(file string) *Schedule {
+	return &Schedule{
+		file:  file,
+		items: make(map[string]*Expiry),
+	}
+}
+
+// cidKey returns the CIDv1 form of c, with or without /ipfs/, so that the v0
+// and v1 forms of the same CID are one key. Anything else is returned as is.
+func cidKey(c string) string {
+	c = strings.TrimPrefix(c, "/ipfs/")
+	id, err := cid.Decode(c)
+	if err != nil {
+		return c
+	}
+	return cid.NewCidV1(id.Type(), id.Hash()).String()
+}
+
+// Load reads the expiries scheduled before the last restart.
+func (s *Schedule) Load() error {
+	var items []*Expiry
+	if ok, err := readJSONFile(s.file, &items); !ok {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, x := range items {
+		s.items[cidKey(x.Cid)] = x
+	}
+	return nil
+}
+
+// save writes out the schedule, soonest expiry first. It must be called
+// with mu held.
+func (s *Schedule) save() error {
+	items := make([]*Expiry, 0, len(s.items))
+	for _, x := range s.items {
+		items = append(items, x)
+	}
+	sort.Slice(items, func(a, b int) bool { return items[a].Expires.Before(items[b].Expires) })
+	return writeJSONFileAtomic(s.file, items)
+}
+
+// Add schedules x, replacing any earlier expiry of the same CID. Pins that
+// expire within expiryWarning are not warned about.
+func (s *Schedule) Add(x Expiry) error {
+	x.Warned = time.Until(x.Expires) <= expiryWarning
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[cidKey(x.Cid)] = &x
+	return s.save()
+}
+
+// retry clears the unpinning mark of c after its unpin failed, so that the
+// next check tries again.
+func (s *Schedule) retry(c string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if x, ok := s.items[cidKey(c)]; ok {
+		x.unpinning = false
+	}
+}
+
+// Remove forgets the expiry of c and reports whether it had one.
+func (s *Schedule) Remove(c string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := cidKey(c)
+	if _, ok := s.items[key]; !ok {
+		return false, nil
+	}
+	delete(s.items, key)
+	return true, s.save()
+}
+
+// Extend postpones the expiry of c by d and returns the new one.
+func (s *Schedule) Extend(c string, d time.Duration) (Expiry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	x, ok := s.items[cidKey(c)]
+	if !ok {
+		return Expiry{}, fmt.Errorf("%s has no expiry", c)
+	}
+	x.Expires = x.Expires.Add(d)
+	x.Warned = time.Until(x.Expires) <= expiryWarning
+	return *x, s.save()
+}
+
+// List returns the scheduled expiries, soonest first.
+func (s *Schedule) List() []Expiry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Expiry, 0, len(s.items))
+	for _, x := range s.items {
+		list = append(list, *x)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Expires.Before(list[b].Expires) })
+	return list
+}
+
+// Run warns about and unpins expiring pins as they come due.
+func (s *Schedule) Run() {
+	for range time.Tick(expiryCheck) {
+		s.check(time.Now())
+	}
+}
+
+// check warns about the pins that expire soon and unpins those that have
+// expired. An expired pin stays in the schedule until the cluster has taken
+// its unpin, which then removes it.
+func (s *Schedule) check(now time.Time) {
+	var warn, expired []Expiry
+	s.mu.Lock()
+	for _, x := range s.items {
+		switch {
+		case x.unpinning:
+		case !now.Before(x.Expires):
+			x.unpinning = true
+			expired = append(expired, *x)
+		case !x.Warned && !now.Before(x.Expires.Add(-expiryWarning)):
+			x.Warned = true
+			warn = append(warn, *x)
+		}
+	}
+	if len(warn) > 0 {
+		if err := s.save(); err != nil {
+			logger.Error("failed to save the expiry schedule", "err", err)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, x := range warn {
+		botMsg(x.Actor, fmt.Sprintf("%s: %s%s will be unpinned in %s. Say %s%s %s <duration> to keep it longer.",
+			x.Nick, x.Cid, quoteLabel(x.Label), formatDuration(time.Until(x.Expires)), prefix, cmdExtend, x.Cid))
+	}
+	for _, x := range expired {
+		l := logger.New("actor", x.Nick, "channel", x.Actor, "cid", x.Cid)
+		_, err := pool.Submit(expirySender.Nick, func() {
+			botMsg(x.Actor, fmt.Sprintf("%s: %s%s has expired, unpinning it", x.Nick, x.Cid, quoteLabel(x.Label)))
+			if _, err := UnpinCluster(x.Actor, expirySender, x.Cid); err != nil {
+				l.Warn("failed to unpin expired pin, will try again", "err", err)
+				s.retry(x.Cid)
+			}
+		})
+		if err != nil {
+			// try again on the next check
+			l.Warn("could not unpin expired pin", "err", err)
+			s.retry(x.Cid)
+			continue
+		}
+		l.Info("unpinning expired pin")
+	}
+}
+
+func quoteLabel(label string) string {
+	if label == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (%q)", label)
+}
+
+// ExtendCmd postpones the expiry of path by d and tells actor.
+func ExtendCmd(actor, path string, d time.Duration) {
+	x, err := schedule.Extend(path, d)
+	if err != nil {
+		botMsg(actor, fmt.Sprintf("failed to extend: %s", err))
+		return
+	}
+	botMsg(actor, fmt.Sprintf("%s now expires %s", x.Cid, x.Expires.UTC().Format("2006-01-02 15:04")))
+}
+
+// ExpiringCmd lists the upcoming expiries to actor.
+func ExpiringCmd(actor string) {
+	list := schedule.List()
+	if len(list) == 0 {
+		botMsg(actor, "no pins are due to expire")
+		return
+	}
+	lines := make([]string, len(list))
+	for i, x := range list {
+		lines[i] = x.String()
+	}
+	sendLong(actor, fmt.Sprintf("%d pins due to expire", len(list)), lines)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testCidV0 = "QmbTdsZpRdVC7au7jLtkMwD6PRJPvfPvdRzG817PnxR2pR"
+	testCidV1 = "bafybeigc6igkody2g3nlkrmbeaavhhvf5cldma3hsszkvaxrd5bzjbejwi"
+)
+
+// withQueues gives the test a message queue to read replies from and a pool
+// without workers, which takes commands without running them.
+func withQueues(t *testing.T) {
+	oldMsgs, oldPool := msgs, pool
+	msgs = make(chan msgWrap, 100)
+	pool = NewWorkerPool(0, 10)
+	t.Cleanup(func() {
+		msgs, pool = oldMsgs, oldPool
+	})
+}
+
+// queued returns the messages queued so far.
+func queued() []msgWrap {
+	var out []msgWrap
+	for {
+		select {
+		case m := <-msgs:
+			out = append(out, m)
+		default:
+			return out
+		}
+	}
+}
+
+func TestScheduleCheck(t *testing.T) {
+	withQueues(t)
+	file := filepath.Join(t.TempDir(), "expiry.json")
+	s := NewSchedule(file)
+
+	now := time.Now()
+	add := func(c string, in time.Duration) {
+		t.Helper()
+		err := s.Add(Expiry{Cid: c, Nick: "alice", Actor: "#pinbot", Expires: now.Add(in)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// expiring within a day of being pinned, so not warned about
+	add("soon", time.Hour)
+	add("later", 3*day)
+	add("far", 30*day)
+
+	s.check(now)
+	if m := queued(); len(m) > 0 {
+		t.Errorf("queued %v, want nothing yet", m)
+	}
+
+	then := now.Add(2*day + time.Hour)
+	s.check(then)
+	m := queued()
+	if len(m) != 1 || m[0].actor != "#pinbot" || !strings.HasPrefix(m[0].message, "alice: later will be unpinned in") {
+		t.Errorf("queued %v, want one warning about later", m)
+	}
+	pool.mu.Lock()
+	submitted := pool.queued
+	pool.mu.Unlock()
+	if submitted != 1 {
+		t.Errorf("submitted %d unpins, want 1", submitted)
+	}
+
+	// warnings are not repeated, and the expired pin, whose unpin has not
+	// run yet, is not submitted again
+	s.check(then)
+	if m := queued(); len(m) > 0 {
+		t.Errorf("queued %v on the second check, want nothing", m)
+	}
+	pool.mu.Lock()
+	submitted = pool.queued
+	pool.mu.Unlock()
+	if submitted != 1 {
+		t.Errorf("submitted %d unpins after the second check, want 1", submitted)
+	}
+	want := []string{"soon", "later", "far"}
+	checkSchedule := func(s *Schedule) {
+		t.Helper()
+		list := s.List()
+		if len(list) != len(want) {
+			t.Fatalf("schedule holds %v, want %v", list, want)
+		}
+		for i, x := range list {
+			if x.Cid != want[i] {
+				t.Errorf("expiry %d is %s, want %s", i, x.Cid, want[i])
+			}
+		}
+		if !list[1].Warned || list[2].Warned {
+			t.Errorf("warned %v, %v, want true, false", list[1].Warned, list[2].Warned)
+		}
+	}
+	checkSchedule(s)
+
+	loaded := NewSchedule(file)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	checkSchedule(loaded)
+}
+
+func TestScheduleCidForms(t *testing.T) {
+	s := NewSchedule(filepath.Join(t.TempDir(), "expiry.json"))
+	expires := time.Now().Add(3 * day)
+	if err := s.Add(Expiry{Cid: testCidV0, Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []string{testCidV1, "/ipfs/" + testCidV1, "/ipfs/" + testCidV0} {
+		x, err := s.Extend(c, day)
+		if err != nil {
+			t.Fatalf("Extend(%s): %s", c, err)
+		}
+		expires = expires.Add(day)
+		if !x.Expires.Equal(expires) || x.Cid != testCidV0 {
+			t.Errorf("Extend(%s) = %s %s, want %s %s", c, x.Cid, x.Expires, testCidV0, expires)
+		}
+	}
+
+	removed, err := s.Remove(testCidV1)
+	if err != nil || !removed {
+		t.Fatalf("Remove(%s) = %v, %v, want true", testCidV1, removed, err)
+	}
+	if list := s.List(); len(list) != 0 {
+		t.Errorf("schedule still holds %v", list)
+	}
+	if removed, _ := s.Remove(testCidV0); removed {
+		t.Error("removed an expiry twice")
+	}
+}
+
+func TestScheduleUnpinFails(t *testing.T) {
+	withQueues(t)
+	withJournal(t)
+	pool = NewWorkerPool(1, 10)
+	oldJobs, oldClients := jobs, clients.Load()
+	jobs = NewJobManager(filepath.Join(t.TempDir(), "jobs.json"))
+	t.Cleanup(func() {
+		jobs = oldJobs
+		clients.Store(oldClients)
+	})
+
+	// a cluster that fails every request
+	var unpins atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			unpins.Add(1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"code": 500, "message": "datastore unavailable"}`))
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	cl, err := setupClients(&Config{Cluster: ClusterConfig{Peers: []PeerConfig{{Addr: "/ip4/127.0.0.1/tcp/" + port}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients.Store(cl)
+
+	s := NewSchedule(filepath.Join(t.TempDir(), "expiry.json"))
+	now := time.Now()
+	if err := s.Add(Expiry{Cid: testCidV0, Nick: "alice", Actor: "#pinbot", Expires: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// every check after the expiry tries again, as the last unpin failed
+	for i := 1; i <= 2; i++ {
+		s.check(now.Add(2 * time.Hour))
+		pool.Wait(time.Now().Add(5 * time.Second))
+		if n := unpins.Load(); n < int32(i) {
+			t.Fatalf("check %d: %d unpins reached the cluster, want %d", i, n, i)
+		}
+		list := s.List()
+		if len(list) != 1 || list[0].Cid != testCidV0 || list[0].unpinning {
+			t.Fatalf("check %d: schedule holds %+v, want the expiry back for another try", i, list)
+		}
+	}
+	found := false
+	for _, m := range queued() {
+		found = found || strings.Contains(m.message, "failed to unpin in cluster")
+	}
+	if !found {
+		t.Error("nobody was told the unpin failed")
+	}
+}
@@ -3,71 +3,268 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"maps"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 var friendsFile = "friends"
 
-const (
-	AdminPerm = "admin"
-	PinPerm   = "pin"
-)
+var DefaultFriends = map[string]Friend{
+	"whyrusleeping": {Name: "whyrusleeping", Role: AdminRole},
+	"jbenet":        {Name: "jbenet", Role: AdminRole},
+	"lgierth":       {Name: "lgierth", Role: AdminRole},
+}
+
+// Friend is an entry in the friends list, granting Name the commands of
+// Role until Expires (if set). A friend proves who they are either by being
+// logged in to the services account Account, by matching the
+// nick!user@host pattern Hostmask, or both when both are set. When neither
+// is set the account name defaults to Name. Insecure entries are trusted on
+// their nick alone.
+type Friend struct {
+	Name     string
+	Role     string
+	Account  string
+	Hostmask string
+	// Matrix is the friend's Matrix user ID, such as @alice:example.org.
+	Matrix   string
+	Insecure bool
+	Expires  time.Time
+}
+
+// Expired reports whether the grant of f has run out at now.
+func (f Friend) Expired(now time.Time) bool {
+	return !f.Expires.IsZero() && !now.Before(f.Expires)
+}
+
+// String returns the friends file representation of f.
+func (f Friend) String() string {
+	parts := []string{f.Name, f.Role}
+	if f.Account != "" {
+		parts = append(parts, "account="+f.Account)
+	}
+	if f.Hostmask != "" {
+		parts = append(parts, "host="+f.Hostmask)
+	}
+	if f.Matrix != "" {
+		parts = append(parts, "matrix="+f.Matrix)
+	}
+	if f.Insecure {
+		parts = append(parts, "insecure")
+	}
+	if !f.Expires.IsZero() {
+		parts = append(parts, "expires="+f.Expires.UTC().Format(time.RFC3339))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Sender identifies whoever sent a message.
+type Sender struct {
+	Nick string
+	User string
+	Host string
+	// MatrixID is set instead of User and Host for senders on Matrix,
+	// whose homeserver has already authenticated them.
+	MatrixID string
+	// Friend is set for senders that have already proven to be the named
+	// friend, such as HTTP API clients with a valid token.
+	Friend string
+}
 
-var DefaultFriendsList = FriendsList{
-	friends: map[string]string{
-		"whyrusleeping": "admin",
-		"jbenet":        "admin",
-		"lgierth":       "admin",
-	},
+// Mask returns the nick!user@host form of s.
+func (s Sender) Mask() string {
+	return s.Nick + "!" + s.User + "@" + s.Host
+}
+
+// account returns the Matrix ID of s, or else its services account if it
+// is known.
+func (s Sender) account() string {
+	if s.MatrixID != "" {
+		return s.MatrixID
+	}
+	if s.Friend != "" {
+		return ""
+	}
+	return accounts.Cached(s.Nick)
 }
 
 type FriendsList struct {
-	friends map[string]string
+	mu      sync.Mutex
+	friends map[string]Friend
 }
 
-func (fl *FriendsList) CanPin(name string) bool {
-	switch fl.friends[name] {
-	case AdminPerm:
-		return true
-	case PinPerm:
-		return true
-	default:
-		return false
+// Set replaces the whole friends list.
+func (fl *FriendsList) Set(f map[string]Friend) {
+	fl.mu.Lock()
+	fl.friends = f
+	fl.mu.Unlock()
+}
+
+// All returns a copy of the friends list.
+func (fl *FriendsList) All() map[string]Friend {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	f := make(map[string]Friend, len(fl.friends))
+	for n, fr := range fl.friends {
+		f[n] = fr
+	}
+	return f
+}
+
+// Names returns the names of all friends, sorted.
+func (fl *FriendsList) Names() []string {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	return fl.names()
+}
+
+func (fl *FriendsList) names() []string {
+	names := make([]string, 0, len(fl.friends))
+	for n := range fl.friends {
+		names = append(names, n)
 	}
+	sort.Strings(names)
+	return names
 }
 
-func (fl *FriendsList) CanAddFriends(name string) bool {
-	switch fl.friends[name] {
-	case AdminPerm:
+// Lookup returns the friend that s can prove to be. Account-backed entries
+// cause the account of s to be looked up (at most once per call). Matrix
+// senders only match friends by their Matrix ID, and senders with a Friend
+// set only match that friend.
+func (fl *FriendsList) Lookup(s Sender) (Friend, bool) {
+	fl.mu.Lock()
+	entries := make([]Friend, 0, len(fl.friends))
+	for _, f := range fl.friends {
+		entries = append(entries, f)
+	}
+	fl.mu.Unlock()
+
+	now := time.Now()
+	var account string
+	var looked bool
+	for _, f := range entries {
+		if f.Expired(now) {
+			continue
+		}
+
+		if s.Friend != "" {
+			if f.Name == s.Friend {
+				return f, true
+			}
+			continue
+		}
+		if s.MatrixID != "" {
+			if f.Matrix != "" && f.Matrix == s.MatrixID {
+				return f, true
+			}
+			continue
+		}
+		if f.Matrix != "" && f.Account == "" && f.Hostmask == "" && !f.Insecure {
+			// Matrix only
+			continue
+		}
+
+		if f.Insecure {
+			if strings.EqualFold(f.Name, s.Nick) {
+				return f, true
+			}
+			continue
+		}
+
+		if f.Hostmask != "" && !matchMask(f.Hostmask, s.Mask()) {
+			continue
+		}
+
+		acct := f.Account
+		if acct == "" && f.Hostmask == "" {
+			acct = f.Name
+		}
+		if acct == "" {
+			// hostmask only, and it matched
+			return f, true
+		}
+
+		if !looked {
+			account = accounts.Lookup(s.Nick)
+			looked = true
+		}
+		if account != "" && strings.EqualFold(acct, account) {
+			return f, true
+		}
+	}
+	return Friend{}, false
+}
+
+// Can reports whether s may run cmd, either because everyone may or because
+// s is a friend whose role grants it.
+func (fl *FriendsList) Can(s Sender, cmd string) bool {
+	rs := currentRoles()
+	if rs.Allows(EveryoneRole, cmd) {
 		return true
-	default:
+	}
+	f, ok := fl.Lookup(s)
+	if !ok {
 		return false
 	}
+	return rs.Allows(f.Role, cmd)
 }
 
-func (fl *FriendsList) AddFriend(name, perm string) error {
-	if !validPerm(perm) {
-		return fmt.Errorf("invalid perm: %s", perm)
+func (fl *FriendsList) AddFriend(f Friend) error {
+	if !currentRoles().Valid(f.Role) {
+		return fmt.Errorf("invalid role: %s", f.Role)
 	}
 
-	fl.friends[name] = perm
-	return fl.Write()
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fl.friends[f.Name] = f
+	return fl.write()
 }
 
 func (fl *FriendsList) RmFriend(name string) error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
 	delete(fl.friends, name)
-	return fl.Write()
+	return fl.write()
+}
+
+// Expire removes every friend whose grant has run out at now and returns
+// them.
+func (fl *FriendsList) Expire(now time.Time) ([]Friend, error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	var expired []Friend
+	for _, n := range fl.names() {
+		if f := fl.friends[n]; f.Expired(now) {
+			expired = append(expired, f)
+			delete(fl.friends, n)
+		}
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+	return expired, fl.write()
 }
 
 func (fl *FriendsList) Write() error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	return fl.write()
+}
+
+func (fl *FriendsList) write() error {
 	f, err := os.Create(friendsFile)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	for n, p := range fl.friends {
-		_, err := fmt.Fprintf(f, "%s %s\n", n, p)
+	for _, n := range fl.names() {
+		_, err := fmt.Fprintln(f, fl.friends[n])
 		if err != nil {
 			return err
 		}
@@ -75,6 +272,33 @@ func (fl *FriendsList) Write() error {
 	return nil
 }
 
+// Reload reads the friends file again, checking roles against rs, or takes
+// the default friends when there is none. The new list replaces the current
+// one only if apply, which is given both, returns nil. The list stays locked
+// throughout, so a friend added or removed meanwhile is not lost; apply must
+// not use fl.
+func (fl *FriendsList) Reload(rs Roles, apply func(cur, f map[string]Friend) error) error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	f := maps.Clone(DefaultFriends)
+	buf, err := os.ReadFile(friendsFile)
+	if err == nil {
+		f, err = fl.Parse(buf, rs)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", friendsFile, err)
+	}
+
+	if err := apply(fl.friends, f); err != nil {
+		return err
+	}
+	fl.friends = f
+	return nil
+}
+
 func (fl *FriendsList) Load() error {
 	buf, err := os.ReadFile(friendsFile)
 	if err != nil {
@@ -82,48 +306,109 @@ func (fl *FriendsList) Load() error {
 	}
 
 	// clear friends map
-	f, err := fl.Parse(buf)
+	f, err := fl.Parse(buf, currentRoles())
 	if err != nil {
 		return err
 	}
-	fl.friends = f
+	fl.Set(f)
 	return nil
 }
 
-func (fl *FriendsList) Parse(buf []byte) (f map[string]string, err error) {
-	f = make(map[string]string)
+// Parse reads a friends file. Each line has the form
+//
+//	<name> <role> [account=<account>] [host=<nick!user@host>] [matrix=<@user:server>] [insecure] [expires=<RFC3339 time>]
+//
+// Lines with just a name and a role remain valid. Roles are checked against rs.
+func (fl *FriendsList) Parse(buf []byte, rs Roles) (f map[string]Friend, err error) {
+	f = make(map[string]Friend)
 	for _, l := range bytes.Split(buf, []byte("\n")) {
 		if len(l) < 3 {
 			continue
 		}
 
-		parts := bytes.Split(l, []byte(" "))
-		if len(parts) != 2 {
-			return f, fmt.Errorf("format error. too many parts. %s", parts)
+		fr, err := parseFriend(strings.Fields(string(l)), rs)
+		if err != nil {
+			return f, err
 		}
+		f[fr.Name] = fr
+	}
 
-		user := string(parts[0])
-		if len(user) < 1 {
-			return f, fmt.Errorf("invalid user: %s", user)
-		}
+	// ok everything seems good
+	return f, nil
+}
 
-		perm := string(parts[1])
-		if !validPerm(perm) {
-			return f, fmt.Errorf("invalid perm: %s", perm)
+// parseFriend parses the fields of a friends file line, which are also the
+// arguments to the befriend command, given the roles rs.
+func parseFriend(parts []string, rs Roles) (Friend, error) {
+	if len(parts) < 2 {
+		return Friend{}, fmt.Errorf("format error. not enough parts. %s", parts)
+	}
+
+	f := Friend{Name: parts[0], Role: parts[1]}
+	if len(f.Name) < 1 {
+		return f, fmt.Errorf("invalid user: %s", f.Name)
+	}
+	if !rs.Valid(f.Role) {
+		return f, fmt.Errorf("invalid role: %s", f.Role)
+	}
+
+	for _, opt := range parts[2:] {
+		switch {
+		case opt == "insecure":
+			f.Insecure = true
+		case strings.HasPrefix(opt, "account="):
+			f.Account = strings.TrimPrefix(opt, "account=")
+		case strings.HasPrefix(opt, "host="):
+			f.Hostmask = strings.TrimPrefix(opt, "host=")
+		case strings.HasPrefix(opt, "matrix="):
+			f.Matrix = strings.TrimPrefix(opt, "matrix=")
+			if !validMatrixID(f.Matrix) {
+				return f, fmt.Errorf("%s: invalid Matrix user ID: %s", f.Name, f.Matrix)
+			}
+		case strings.HasPrefix(opt, "expires="):
+			t, err := time.Parse(time.RFC3339, strings.TrimPrefix(opt, "expires="))
+			if err != nil {
+				return f, fmt.Errorf("%s: invalid expiry: %s", f.Name, err)
+			}
+			f.Expires = t
+		default:
+			return f, fmt.Errorf("format error. unknown option: %s", opt)
 		}
-		f[user] = perm
 	}
 
-	// ok everything seems good
+	if f.Insecure && (f.Account != "" || f.Hostmask != "") {
+		return f, fmt.Errorf("%s: insecure cannot be combined with account or host", f.Name)
+	}
 	return f, nil
 }
 
-func validPerm(perm string) bool {
-	switch perm {
-	case AdminPerm:
-	case PinPerm:
-	default:
-		return false
+// matchMask reports whether s matches the IRC-style wildcard pattern, where
+// '*' matches any run of characters and '?' matches exactly one. Matching
+// is case-insensitive.
+func matchMask(pattern, s string) bool {
+	p := []rune(strings.ToLower(pattern))
+	str := []rune(strings.ToLower(s))
+
+	var pi, si int
+	star, mark := -1, 0
+	for si < len(str) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == str[si]):
+			pi++
+			si++
+		case pi < len(p) && p[pi] == '*':
+			star, mark = pi, si
+			pi++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			si = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
 	}
-	return true
+	return pi == len(p)
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMatchMask(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*!*@*", "nick!user@host", true},
+		{"nick!user@host", "nick!user@host", true},
+		{"NICK!*@*", "nick!user@host", true},
+		{"nick!*@*.example.org", "nick!user@irc.example.org", true},
+		{"nick!*@*.example.org", "nick!user@example.org", false},
+		{"n?ck!*@*", "nick!user@host", true},
+		{"n?ck!*@*", "nck!user@host", false},
+		{"*!user@host", "anyone!user@host", true},
+		{"*!user@host", "anyone!user@host2", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"*", "", true},
+		{"", "x", false},
+		{"ni*ck", "nick", true},
+	}
+	for _, tt := range tests {
+		if got := matchMask(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchMask(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestParseFriend(t *testing.T) {
+	expires := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		line    []string
+		want    Friend
+		wantErr bool
+	}{
+		{
+			line: []string{"alice", "pinner"},
+			want: Friend{Name: "alice", Role: "pinner"},
+		},
+		{
+			line: []string{"alice", "pinner", "account=alice_", "host=alice!*@*.example.org"},
+			want: Friend{Name: "alice", Role: "pinner", Account: "alice_", Hostmask: "alice!*@*.example.org"},
+		},
+		{
+			line: []string{"alice", "admin", "insecure"},
+			want: Friend{Name: "alice", Role: "admin", Insecure: true},
+		},
+		{
+			line: []string{"alice", "viewer", "matrix=@alice:example.org"},
+			want: Friend{Name: "alice", Role: "viewer", Matrix: "@alice:example.org"},
+		},
+		{
+			line: []string{"alice", "pinner", "expires=2026-11-01T12:00:00Z"},
+			want: Friend{Name: "alice", Role: "pinner", Expires: expires},
+		},
+		{line: []string{"alice"}, wantErr: true},
+		{line: []string{"alice", "nosuchrole"}, wantErr: true},
+		{line: []string{"alice", "pinner", "bogus"}, wantErr: true},
+		{line: []string{"alice", "pinner", "expires=tomorrow"}, wantErr: true},
+		{line: []string{"alice", "pinner", "matrix=alice"}, wantErr: true},
+		{line: []string{"alice", "pinner", "insecure", "account=alice"}, wantErr: true},
+		{line: []string{"alice", "pinner", "insecure", "host=*!*@*"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseFriend(tt.line, DefaultRoles)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseFriend(%q) succeeded, want an error", tt.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseFriend(%q): %s", tt.line, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseFriend(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestFriendStringRoundTrip(t *testing.T) {
+	f := Friend{
+		Name:     "alice",
+		Role:     "pinner",
+		Account:  "alice_",
+		Hostmask: "alice!*@*",
+		Expires:  time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC),
+	}
+	parsed, err := (&FriendsList{}).Parse([]byte(f.String()+"\n"), DefaultRoles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed["alice"]; got != f {
+		t.Errorf("round trip gave %+v, want %+v", got, f)
+	}
+}
+
+func TestFriendsReload(t *testing.T) {
+	withFriends(t, map[string]Friend{})
+	old := friendsFile
+	friendsFile = filepath.Join(t.TempDir(), "friends")
+	t.Cleanup(func() { friendsFile = old })
+
+	// without a file the defaults apply, and changes do not leak into them
+	err := friends.Reload(DefaultRoles, func(cur, f map[string]Friend) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := friends.AddFriend(Friend{Name: "alice", Role: "pinner"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := DefaultFriends["alice"]; ok {
+		t.Fatal("adding a friend changed DefaultFriends")
+	}
+
+	// a reload that is not applied keeps the list
+	err = friends.Reload(DefaultRoles, func(cur, f map[string]Friend) error {
+		if _, ok := cur["alice"]; !ok {
+			t.Error("apply was not given the current list")
+		}
+		return errors.New("not now")
+	})
+	if err == nil {
+		t.Error("Reload succeeded although apply failed")
+	}
+	if _, ok := friends.All()["alice"]; !ok {
+		t.Error("a failed reload replaced the list")
+	}
+
+	os.WriteFile(friendsFile, []byte("bob viewer\n"), 0660)
+	err = friends.Reload(DefaultRoles, func(cur, f map[string]Friend) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := friends.Names(); len(names) != 1 || names[0] != "bob" {
+		t.Errorf("friends are %v after reloading, want [bob]", names)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PinQuery selects pins from the journal. Zero fields match everything.
+type PinQuery struct {
+	Label string
+	By    string
+	Since time.Time
+	Cid   string
+	// Ops limits the operations matched; pins only when empty.
+	Ops []string
+}
+
+func (q PinQuery) matches(e JournalEntry) bool {
+	ops := q.Ops
+	if len(ops) == 0 {
+		ops = []string{OpPin}
+	}
+	var opOK bool
+	for _, op := range ops {
+		opOK = opOK || e.Op == op
+	}
+	if !opOK {
+		return false
+	}
+
+	if e.Result == ResultFailed && e.Cid == "" {
+		// never made it to the cluster
+		return false
+	}
+	if q.Label != "" && !strings.Contains(strings.ToLower(e.Label), strings.ToLower(q.Label)) {
+		return false
+	}
+	if q.By != "" && !strings.EqualFold(e.Nick, q.By) && !strings.EqualFold(e.Account, q.By) {
+		return false
+	}
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if q.Cid != "" && e.Cid != q.Cid {
+		return false
+	}
+	return true
+}
+
+// SearchPins returns the operations in the journal matching q, newest
+// first.
+func SearchPins(q PinQuery) ([]JournalEntry, error) {
+	ops, err := journal.Ops()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []JournalEntry
+	for i := len(ops) - 1; i >= 0; i-- {
+		if q.matches(ops[i]) {
+			found = append(found, ops[i])
+		}
+	}
+	return found, nil
+}
+
+// parsePinQuery parses the arguments of the pins command:
+//
+//	<label substring> | by <nick> | since <date or duration>
+func parsePinQuery(args []string, now time.Time) (q PinQuery, err error) {
+	if len(args) == 0 {
+		return q, fmt.Errorf("nothing to search for")
+	}
+
+	switch {
+	case args[0] == "by" && len(args) == 2:
+		q.By = args[1]
+	case args[0] == "since" && len(args) == 2:
+		if t, err := time.Parse(time.DateOnly, args[1]); err == nil {
+			q.Since = t
+		} else if d, err := parseDuration(args[1]); err == nil {
+			q.Since = now.Add(-d)
+		} else {
+			return q, fmt.Errorf("invalid date or duration: %s", args[1])
+		}
+	default:
+		q.Label = strings.Join(args, " ")
+	}
+	return q, nil
+}
+
+var opPast = map[string]string{
+	OpPin:     "pinned",
+	OpUnpin:   "unpinned",
+	OpRecover: "recovered",
+}
+
+// formatPin renders a journal operation on a single line.
+func formatPin(e JournalEntry) string {
+	when := "before the journal"
+	if !e.Time.IsZero() {
+		when = e.Time.UTC().Format("2006-01-02 15:04")
+	}
+	who := e.Nick
+	if who == "" {
+		who = "unknown"
+	}
+	target := e.Cid
+	if target == "" {
+		target = e.Path
+	}
+
+	out := fmt.Sprintf("%s %s %s by %s", when, opPast[e.Op], target, who)
+	if e.Label != "" {
+		out += fmt.Sprintf(" as %q", e.Label)
+	}
+	if e.Options != nil {
+		out += " with " + e.Options.String()
+	}
+	if e.Result != "" {
+		out += " (" + e.Result + ")"
+	}
+	return out
+}
+
+// listPins sends results to actor through sendLong.
+func listPins(actor string, results []JournalEntry) {
+	if len(results) == 0 {
+		botMsg(actor, "no pins found")
+		return
+	}
+	lines := make([]string, len(results))
+	for i, e := range results {
+		lines[i] = formatPin(e)
+	}
+	sendLong(actor, fmt.Sprintf("%d pins found", len(results)), lines)
+}
+
+// SearchPinsCmd answers the pins command.
+func SearchPinsCmd(actor string, args []string) {
+	q, err := parsePinQuery(args, time.Now())
+	if err != nil {
+		botMsg(actor, err.Error())
+		return
+	}
+
+	results, err := SearchPins(q)
+	if err != nil {
+		botMsg(actor, fmt.Sprintf("failed to read pin journal: %s", err))
+		return
+	}
+	listPins(actor, results)
+}
+
+// WhoisCmd tells actor who pinned and unpinned the given cid, and when.
+func WhoisCmd(actor, path string) {
+	// pick up a random shell
+	shell := clients.Load().randomShell()
+
+	c, err := resolveCid(path, shell)
+	if err != nil {
+		botMsg(actor, fmt.Sprintf("could not resolve cid: %s", err))
+		return
+	}
+
+	results, err := SearchPins(PinQuery{Cid: c.String(), Ops: []string{OpPin, OpUnpin}})
+	if err != nil {
+		botMsg(actor, fmt.Sprintf("failed to read pin journal: %s", err))
+		return
+	}
+	if len(results) == 0 {
+		botMsg(actor, fmt.Sprintf("I have no record of %s", c))
+		return
+	}
+	listPins(actor, results)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePinQuery(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		args []string
+		want PinQuery
+	}{
+		{[]string{"website"}, PinQuery{Label: "website"}},
+		{[]string{"my", "site"}, PinQuery{Label: "my site"}},
+		{[]string{"by", "alice"}, PinQuery{By: "alice"}},
+		{[]string{"since", "2026-10-01"}, PinQuery{Since: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}},
+		{[]string{"since", "7d"}, PinQuery{Since: now.Add(-7 * day)}},
+		// not a search by nick after all
+		{[]string{"by", "the", "way"}, PinQuery{Label: "by the way"}},
+	}
+	for _, tt := range tests {
+		got, err := parsePinQuery(tt.args, now)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePinQuery(%q) = %+v, %v, want %+v", tt.args, got, err, tt.want)
+		}
+	}
+
+	for _, args := range [][]string{nil, {"since", "whenever"}} {
+		if q, err := parsePinQuery(args, now); err == nil {
+			t.Errorf("parsePinQuery(%q) = %+v, want an error", args, q)
+		}
+	}
+}
+
+func TestSearchPins(t *testing.T) {
+	j := withJournal(t)
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	records := []JournalEntry{
+		{ID: "a", Op: OpPin, Nick: "alice", Label: "Website v1", Cid: "one", Result: "pinned"},
+		{ID: "b", Op: OpPin, Nick: "bob", Account: "robert", Label: "docs", Cid: "two", Result: "pinned"},
+		{ID: "c", Op: OpUnpin, Nick: "alice", Cid: "one", Result: "unpinned"},
+		{ID: "d", Op: OpPin, Nick: "alice", Label: "website v2", Path: "/ipns/nowhere", Result: ResultFailed},
+		{ID: "e", Op: OpPin, Nick: "carol", Label: "website v2", Cid: "three", Result: ResultSubmitted},
+	}
+	for i := range records {
+		records[i].Time = start.Add(time.Duration(i) * day)
+		if err := j.Append(&records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		q    PinQuery
+		want []string
+	}{
+		// newest first, and never the pin that failed to reach the cluster
+		{PinQuery{Label: "WEBSITE"}, []string{"e", "a"}},
+		{PinQuery{By: "alice"}, []string{"a"}},
+		{PinQuery{By: "robert"}, []string{"b"}},
+		{PinQuery{Since: start.Add(day)}, []string{"e", "b"}},
+		{PinQuery{Cid: "one", Ops: []string{OpPin, OpUnpin}}, []string{"c", "a"}},
+		{PinQuery{Label: "nothing"}, nil},
+	}
+	for _, tt := range tests {
+		found, err := SearchPins(tt.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, e := range found {
+			ids = append(ids, e.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("SearchPins(%+v) = %v, want %v", tt.q, ids, tt.want)
+		}
+	}
+}
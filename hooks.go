@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// maxHookBody limits the size of inbound webhook payloads.
+const maxHookBody = 5 << 20
+
+// errIgnored is returned for payloads that are valid but ask for nothing
+// to be pinned, such as an unpublished release.
+var errIgnored = errors.New("nothing to pin")
+
+// hookRequest is what an inbound webhook asks to pin. Note is kept with the
+// version when the label names one.
+type hookRequest struct {
+	Cid   string `json:"cid"`
+	Label string `json:"label"`
+	Note  string `json:"note,omitempty"`
+}
+
+// githubRepo and the types below hold the parts of GitHub's release and
+// deployment events that pinbot uses.
+type githubRepo struct {
+	FullName string `json:"full_name"`
+}
+
+// name returns the name of the repository without its owner.
+func (r githubRepo) name() string {
+	return r.FullName[strings.LastIndexByte(r.FullName, '/')+1:]
+}
+
+// labelChars matches what cannot be part of a label name.
+var labelChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// hookLabel joins parts into a label name, replacing whatever a label name
+// cannot hold with -.
+func hookLabel(parts ...string) string {
+	var words []string
+	for _, p := range parts {
+		if p = strings.Trim(labelChars.ReplaceAllString(p, "-"), "-_."); p != "" {
+			words = append(words, p)
+		}
+	}
+	return strings.Join(words, "-")
+}
+
+type githubRelease struct {
+	Action  string `json:"action"`
+	Release struct {
+		TagName string `json:"tag_name"`
+		Name    string `json:"name"`
+		Body    string `json:"body"`
+	} `json:"release"`
+	Repository githubRepo `json:"repository"`
+}
+
+type githubDeployment struct {
+	Deployment struct {
+		Environment string      `json:"environment"`
+		Ref         string      `json:"ref"`
+		Payload     hookRequest `json:"payload"`
+	} `json:"deployment"`
+	Repository githubRepo `json:"repository"`
+}
+
+// parseHook extracts the CID and label from a GitHub release or deployment
+// event, or from a generic {"cid": ..., "label": ..., "note": ...} object.
+// Releases are labeled with the repository name and noted with their tag,
+// deployments with the repository name and environment and noted with
+// their ref, so that each gets a version history.
+func parseHook(event string, body []byte) (hookRequest, error) {
+	var req hookRequest
+	switch event {
+	case "release":
+		var rel githubRelease
+		if err := json.Unmarshal(body, &rel); err != nil {
+			return req, err
+		}
+		if rel.Action != "published" {
+			return req, errIgnored
+		}
+		req.Cid = findCid(rel.Release.Name + " " + rel.Release.Body)
+		req.Label = hookLabel(rel.Repository.name())
+		req.Note = rel.Release.TagName
+
+	case "deployment":
+		var dep githubDeployment
+		if err := json.Unmarshal(body, &dep); err != nil {
+			return req, err
+		}
+		req = dep.Deployment.Payload
+		if req.Label == "" {
+			req.Label = hookLabel(dep.Repository.name(), dep.Deployment.Environment)
+		}
+		if req.Note == "" {
+			req.Note = dep.Deployment.Ref
+		}
+
+	case "", "generic":
+		if err := json.Unmarshal(body, &req); err != nil {
+			return req, err
+		}
+
+	default:
+		return req, errIgnored
+	}
+
+	req.Label = strings.TrimSpace(req.Label)
+	req.Note = strings.TrimSpace(req.Note)
+	if req.Cid == "" {
+		return req, errors.New("no cid in payload")
+	}
+	if _, err := cid.Decode(strings.TrimPrefix(req.Cid, "/ipfs/")); err != nil {
+		return req, fmt.Errorf("invalid cid %q: %s", req.Cid, err)
+	}
+	return req, nil
+}
+
+// findCid returns the first word of text that is a CID, or "".
+func findCid(text string) string {
+	for _, w := range strings.Fields(text) {
+		w = strings.Trim(w, "`'\"()[]<>,.;:")
+		w = strings.TrimPrefix(w, "/ipfs/")
+		if _, err := cid.Decode(w); err == nil {
+			return w
+		}
+	}
+	return ""
+}
+
+// checkSignature verifies the sha256=<hex> HMAC of body in GitHub's
+// X-Hub-Signature-256 header, or in X-Pinbot-Signature.
+func checkSignature(r *http.Request, secret string, body []byte) bool {
+	sig := r.Header.Get("X-Hub-Signature-256")
+	if sig == "" {
+		sig = r.Header.Get("X-Pinbot-Signature")
+	}
+	want := "sha256=" + sign(secret, body)
+	return hmac.Equal([]byte(sig), []byte(want))
+}
+
+// hook handles POST /hooks/{name}.
+func (a *API) hook(w http.ResponseWriter, r *http.Request) {
+	if shuttingDown.Load() {
+		apiError(w, http.StatusServiceUnavailable, ErrStopped)
+		return
+	}
+
+	var hc *InboundConfig
+	for i := range a.cfg.Inbound {
+		if a.cfg.Inbound[i].Name == r.PathValue("name") {
+			hc = &a.cfg.Inbound[i]
+		}
+	}
+	if hc == nil {
+		apiError(w, http.StatusNotFound, errors.New("no such hook"))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHookBody))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !checkSignature(r, hc.Secret, body) {
+		apiError(w, http.StatusUnauthorized, errors.New("bad signature"))
+		return
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	if event == "ping" {
+		apiReply(w, http.StatusOK, map[string]string{"status": "pong"})
+		return
+	}
+	req, err := parseHook(event, body)
+	if err == errIgnored {
+		apiReply(w, http.StatusOK, map[string]string{"status": "ignored"})
+		return
+	}
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if hc.Label != "" {
+		req.Label = hc.Label
+	}
+	if req.Label == "" {
+		req.Label = "webhook " + hc.Name
+	}
+
+	from := Sender{Nick: "hook:" + hc.Name}
+	pos, err := pool.Submit(from.Nick, func() {
+		if !isLabel(req.Label) {
+			botMsg(a.channel, fmt.Sprintf("webhook %s: pinning %s as %q", hc.Name, req.Cid, req.Label))
+			PinCluster(a.channel, from, req.Cid, req.Label, PinOpts{})
+			return
+		}
+		msg := fmt.Sprintf("webhook %s: pinning %s as a new version of %s", hc.Name, req.Cid, req.Label)
+		if req.Note != "" {
+			msg += " (" + req.Note + ")"
+		}
+		botMsg(a.channel, msg)
+		PinLabel(a.channel, from, req.Label, req.Cid, PinOpts{}, req.Note)
+	})
+	if err != nil {
+		apiError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	apiReply(w, http.StatusAccepted, map[string]interface{}{
+		"status": "queued",
+		"cid":    req.Cid,
+		"label":  req.Label,
+		"note":   req.Note,
+		"queue":  pos,
+	})
+}
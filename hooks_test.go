@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+const testHookCid = "QmbTdsZpRdVC7au7jLtkMwD6PRJPvfPvdRzG817PnxR2pR"
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	buf, err := os.ReadFile("testdata/hooks/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestParseHook(t *testing.T) {
+	tests := []struct {
+		event string
+		body  []byte
+		want  hookRequest
+		err   bool
+	}{
+		{
+			event: "release",
+			body:  readFixture(t, "release.json"),
+			want:  hookRequest{Cid: testHookCid, Label: "website", Note: "v1.4.0"},
+		},
+		{
+			event: "deployment",
+			body:  readFixture(t, "deployment.json"),
+			want:  hookRequest{Cid: testHookCid, Label: "website-production", Note: "main"},
+		},
+		{
+			event: "",
+			body:  readFixture(t, "generic.json"),
+			want:  hookRequest{Cid: testHookCid, Label: "website build 1234"},
+		},
+		{
+			event: "generic",
+			body:  []byte(`{"cid": "/ipfs/` + testHookCid + `"}`),
+			want:  hookRequest{Cid: "/ipfs/" + testHookCid},
+		},
+		{
+			event: "generic",
+			body:  []byte(`{"cid": "` + testHookCid + `", "label": "docs", "note": " build 7 "}`),
+			want:  hookRequest{Cid: testHookCid, Label: "docs", Note: "build 7"},
+		},
+		{
+			event: "deployment",
+			body: []byte(`{"deployment": {"environment": "Staging (EU)", "ref": "v2", "payload": {"cid": "` + testHookCid + `"}},
+				"repository": {"full_name": "ipfs/ipfs.io"}}`),
+			want: hookRequest{Cid: testHookCid, Label: "ipfs.io-Staging-EU", Note: "v2"},
+		},
+		{event: "", body: []byte(`{"label": "no cid"}`), err: true},
+		{event: "", body: []byte(`{"cid": "notacid"}`), err: true},
+		{event: "", body: []byte(`not json`), err: true},
+		{
+			event: "release",
+			body:  []byte(`{"action": "published", "release": {"tag_name": "v1", "body": "no cid here"}}`),
+			err:   true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseHook(tt.event, tt.body)
+		if tt.err {
+			if err == nil || err == errIgnored {
+				t.Errorf("parseHook(%q, %s) = %+v, %v, want an error", tt.event, tt.body, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseHook(%q, %s): %s", tt.event, tt.body, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseHook(%q, %s) = %+v, want %+v", tt.event, tt.body, got, tt.want)
+		}
+		// GitHub events always get a version history
+		if tt.event == "release" || tt.event == "deployment" {
+			if !isLabel(got.Label) {
+				t.Errorf("parseHook(%q, %s): %q cannot name a label", tt.event, tt.body, got.Label)
+			}
+		}
+	}
+
+	ignored := []struct {
+		event string
+		body  string
+	}{
+		{"release", `{"action": "created", "release": {"body": "` + testHookCid + `"}}`},
+		{"push", `{}`},
+	}
+	for _, tt := range ignored {
+		if _, err := parseHook(tt.event, []byte(tt.body)); err != errIgnored {
+			t.Errorf("parseHook(%q, %s): got %v, want errIgnored", tt.event, tt.body, err)
+		}
+	}
+}
+
+func TestHook(t *testing.T) {
+	// a pool without workers takes the pins without running them
+	oldPool := pool
+	pool = NewWorkerPool(0, 10)
+	t.Cleanup(func() { pool = oldPool })
+
+	const secret = "s3cret"
+	a := NewAPI(APIConfig{
+		Inbound: []InboundConfig{
+			{Name: "site", Secret: secret},
+			{Name: "labeled", Secret: secret, Label: "fixed label"},
+		},
+	}, "#pinbot")
+	srv := httptest.NewServer(a.handler())
+	defer srv.Close()
+
+	release := readFixture(t, "release.json")
+	deployment := readFixture(t, "deployment.json")
+	generic := readFixture(t, "generic.json")
+	tests := []struct {
+		name    string
+		hook    string
+		event   string
+		body    []byte
+		header  string
+		sig     string
+		code    int
+		status  string
+		label   string
+		withCid bool
+	}{
+		{
+			name: "github release", hook: "site", event: "release", body: release,
+			header: "X-Hub-Signature-256", sig: "sha256=" + sign(secret, release),
+			code: http.StatusAccepted, status: "queued", label: "website", withCid: true,
+		},
+		{
+			name: "github deployment", hook: "site", event: "deployment", body: deployment,
+			header: "X-Hub-Signature-256", sig: "sha256=" + sign(secret, deployment),
+			code: http.StatusAccepted, status: "queued", label: "website-production", withCid: true,
+		},
+		{
+			name: "generic", hook: "site", body: generic,
+			header: "X-Pinbot-Signature", sig: "sha256=" + sign(secret, generic),
+			code: http.StatusAccepted, status: "queued", label: "website build 1234", withCid: true,
+		},
+		{
+			name: "configured label", hook: "labeled", body: generic,
+			header: "X-Pinbot-Signature", sig: "sha256=" + sign(secret, generic),
+			code: http.StatusAccepted, status: "queued", label: "fixed label", withCid: true,
+		},
+		{
+			name: "github ping", hook: "site", event: "ping", body: []byte(`{}`),
+			header: "X-Hub-Signature-256", sig: "sha256=" + sign(secret, []byte(`{}`)),
+			code: http.StatusOK, status: "pong",
+		},
+		{
+			name: "github signature with the wrong secret", hook: "site", event: "release", body: release,
+			header: "X-Hub-Signature-256", sig: "sha256=" + sign("wrong", release),
+			code: http.StatusUnauthorized,
+		},
+		{
+			name: "pinbot signature of another body", hook: "site", body: generic,
+			header: "X-Pinbot-Signature", sig: "sha256=" + sign(secret, release),
+			code: http.StatusUnauthorized,
+		},
+		{
+			name: "pinbot signature without the sha256= prefix", hook: "site", body: generic,
+			header: "X-Pinbot-Signature", sig: sign(secret, generic),
+			code: http.StatusUnauthorized,
+		},
+		{
+			name: "no signature", hook: "site", body: generic,
+			code: http.StatusUnauthorized,
+		},
+		{
+			name: "unknown hook", hook: "nosuchhook", body: generic,
+			header: "X-Pinbot-Signature", sig: "sha256=" + sign(secret, generic),
+			code: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest("POST", srv.URL+"/hooks/"+tt.hook, bytes.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.event != "" {
+			req.Header.Set("X-GitHub-Event", tt.event)
+		}
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.sig)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got struct {
+			Status string `json:"status"`
+			Cid    string `json:"cid"`
+			Label  string `json:"label"`
+		}
+		json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.code {
+			t.Errorf("%s: got HTTP %d, want %d", tt.name, resp.StatusCode, tt.code)
+			continue
+		}
+		if got.Status != tt.status || got.Label != tt.label {
+			t.Errorf("%s: got status %q, label %q, want %q, %q", tt.name, got.Status, got.Label, tt.status, tt.label)
+		}
+		if tt.withCid && got.Cid != testHookCid {
+			t.Errorf("%s: got cid %q, want %s", tt.name, got.Cid, testHookCid)
+		}
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.queued != 4 {
+		t.Errorf("%d pins queued, want 4", pool.queued)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	hb "github.com/whyrusleeping/hellabot"
+)
+
+// ircTransport talks to IRC through the current hellabot connection.
+type ircTransport struct{}
+
+func (ircTransport) Name() string {
+	return defaultTransport
+}
+
+func (ircTransport) Msg(to, msg string) {
+	bot.Msg(to, msg)
+}
+
+func (ircTransport) Notice(to, msg string) {
+	bot.Notice(to, msg)
+}
+
+const (
+	// ircMaxLine is the longest line IRC servers accept, including the
+	// trailing CRLF.
+	ircMaxLine = 512
+	// ircPrefixReserve leaves room for the ":nick!user@host " prefix the
+	// server adds when relaying our messages.
+	ircPrefixReserve = 100
+)
+
+// ircServer is the server pinbot connects to.
+var ircServer string
+
+// Network makes ircTransport a Networker.
+func (ircTransport) Network() string {
+	if host, _, err := net.SplitHostPort(ircServer); err == nil {
+		return host
+	}
+	return ircServer
+}
+
+// Connected makes ircTransport a Connector. Lines wait until pinbot has
+// registered and joined its channel, which joinedTrigger notes.
+func (ircTransport) Connected() bool {
+	return connected.Load()
+}
+
+// joinedTrigger marks pinbot connected once the server confirms it has
+// joined channel. It lets the message through to the other triggers.
+func joinedTrigger(channel string) hb.Trigger {
+	return hb.Trigger{
+		Condition: func(irc *hb.Bot, mes *hb.Message) bool {
+			return mes.Command == "JOIN" && strings.EqualFold(mes.From, irc.Nick) && strings.EqualFold(mes.To, channel)
+		},
+		Action: func(irc *hb.Bot, mes *hb.Message) bool {
+			if !connected.Swap(true) {
+				logger.Info("joined", "channel", channel)
+			}
+			return false
+		},
+	}
+}
+
+// ircBucket keeps pinbot under the server's flood limits.
+var ircBucket = NewTokenBucket(5, 2*time.Second)
+
+// MaxLine makes ircTransport a Limiter.
+func (ircTransport) MaxLine(to string) int {
+	return ircMaxLine - len("\r\n") - len("PRIVMSG  :") - len(to) - ircPrefixReserve
+}
+
+func (ircTransport) Bucket() *TokenBucket {
+	return ircBucket
+}
+
+// ircCommand is a Command received over IRC.
+type ircCommand struct {
+	mes *hb.Message
+}
+
+func (c ircCommand) Transport() Transport {
+	return ircTransport{}
+}
+
+func (c ircCommand) Sender() Sender {
+	return senderOf(c.mes)
+}
+
+func (c ircCommand) Channel() string {
+	return c.mes.To
+}
+
+func (c ircCommand) Private() string {
+	return c.Sender().Nick
+}
+
+func (c ircCommand) Text() string {
+	return c.mes.Content
+}
+
+// commandTrigger hands IRC messages to the command handlers.
+var commandTrigger = hb.Trigger{
+	Condition: func(irc *hb.Bot, mes *hb.Message) bool {
+		return strings.HasPrefix(mes.Content, prefix)
+	},
+	Action: func(irc *hb.Bot, mes *hb.Message) bool {
+		return Handle(ircCommand{mes})
+	},
+}
+
+var EatEverything = hb.Trigger{
+	Condition: func(irc *hb.Bot, mes *hb.Message) bool {
+		return true
+	},
+	Action: func(irc *hb.Bot, mes *hb.Message) bool {
+		//fmt.Println(mes)
+		return true
+	},
+}
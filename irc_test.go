@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	hb "github.com/whyrusleeping/hellabot"
+)
+
+func TestJoinedTrigger(t *testing.T) {
+	t.Cleanup(func() { connected.Store(false) })
+	bot := &hb.Bot{Nick: "pinbot"}
+	trigger := joinedTrigger("#ipfs-pinbot")
+	tests := []struct {
+		raw       string
+		connected bool
+	}{
+		// registered, but not in the channel yet
+		{":irc.example.org 001 pinbot :Welcome", false},
+		{":alice!a@host JOIN #ipfs-pinbot", false},
+		{":pinbot!p@host JOIN #elsewhere", false},
+		{":pinbot!p@host JOIN #ipfs-pinbot", true},
+		{":PinBot!p@host JOIN :#IPFS-pinbot", true},
+	}
+	for _, tt := range tests {
+		connected.Store(false)
+		if trigger.Handle(bot, hb.ParseMessage(tt.raw)) {
+			t.Errorf("%s: consumed, want it passed on", tt.raw)
+		}
+		if got := connected.Load(); got != tt.connected {
+			t.Errorf("%s: connected = %v, want %v", tt.raw, got, tt.connected)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/ipfs-cluster/api"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// jobTimeout is how long a job waits for the cluster before giving up.
+var jobTimeout = time.Hour
+
+// Job states.
+const (
+	// JobRunning jobs are still talking to the nodes or the cluster.
+	JobRunning = "running"
+	// JobWaiting jobs have been submitted and wait for the cluster to
+	// reach their target status.
+	JobWaiting = "waiting"
+)
+
+// Job is a pin, unpin or recover whose outcome has not been reported yet.
+// Jobs are persisted so that they survive restarts, although only waiting
+// jobs can be resumed.
+type Job struct {
+	ID       int          `json:"id"`
+	Op       string       `json:"op"`
+	Actor    string       `json:"actor"`
+	Nick     string       `json:"nick"`
+	Cid      string       `json:"cid"`
+	Target   string       `json:"target"`
+	State    string       `json:"state"`
+	Created  time.Time    `json:"created"`
+	Deadline time.Time    `json:"deadline"`
+	Entry    JournalEntry `json:"entry"`
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Age returns how long ago j was created.
+func (j Job) Age() time.Duration {
+	return time.Since(j.Created).Round(time.Second)
+}
+
+// log returns a logger carrying the context of j.
+func (j Job) log() log.Logger {
+	target := j.Cid
+	if target == "" {
+		target = j.Entry.Path
+	}
+	return logger.New("actor", j.Nick, "channel", j.Actor, "cid", target, "job", j.ID, "op", j.Op)
+}
+
+// String describes j on a single line.
+func (j Job) String() string {
+	target := j.Cid
+	if target == "" {
+		target = j.Entry.Path
+	}
+	out := fmt.Sprintf("job %d: %s %s by %s, %s ago, %s", j.ID, j.Op, target, j.Nick, j.Age(), j.State)
+	if j.State == JobWaiting {
+		out += " for " + j.Target
+	}
+	return out
+}
+
+// JobManager keeps track of unfinished jobs and the file they are persisted
+// to.
+type JobManager struct {
+	mu   sync.Mutex
+	file string
+	next int
+	jobs map[int]*Job
+	// frozen stops changes from being persisted once the jobs have been
+	// checkpointed for a shutdown.
+	frozen bool
+}
+
+type jobsFile struct {
+	Next int    `json:"next"`
+	Jobs []*Job `json:"jobs"`
+}
+
+var jobs = NewJobManager("jobs.json")
+
+func NewJobManager(file string) *JobManager {
+	return &JobManager{
+		file: file,
+		next: 1,
+		jobs: make(map[int]*Job),
+	}
+}
+
+// Load reads the jobs the last run left unfinished, if it saved any.
+func (jm *JobManager) Load() error {
+	var jf jobsFile
+	if ok, err := readJSONFile(jm.file, &jf); !ok {
+		return err
+	}
+
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	jm.next = max(jf.Next, 1)
+	for _, j := range jf.Jobs {
+		jm.jobs[j.ID] = j
+	}
+	return nil
+}
+
+// save writes out every job in flight, along with the next job ID, unless
+// a shutdown checkpoint has frozen the file. It must be called with mu
+// held.
+func (jm *JobManager) save() error {
+	if jm.frozen {
+		return nil
+	}
+
+	jf := jobsFile{Next: jm.next}
+	for _, j := range jm.jobs {
+		jf.Jobs = append(jf.Jobs, j)
+	}
+	sort.Slice(jf.Jobs, func(a, b int) bool { return jf.Jobs[a].ID < jf.Jobs[b].ID })
+	return writeJSONFileAtomic(jm.file, jf)
+}
+
+// Checkpoint persists all jobs one last time before a shutdown. Jobs that
+// finish afterwards stay in the file, so they are checked and reported again
+// after the restart rather than lost.
+func (jm *JobManager) Checkpoint() error {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	err := jm.save()
+	jm.frozen = true
+	return err
+}
+
+// Start registers a running job for the operation recorded in e. The
+// returned context is canceled when the job is.
+func (jm *JobManager) Start(e *JournalEntry) (*Job, context.Context) {
+	now := time.Now().UTC()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	jm.mu.Lock()
+	e.Job = jm.next
+	j := &Job{
+		ID:      jm.next,
+		Op:      e.Op,
+		Actor:   e.Channel,
+		Nick:    e.Nick,
+		State:   JobRunning,
+		Created: now,
+		Entry:   *e,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	jm.next++
+	jm.jobs[j.ID] = j
+	err := jm.save()
+	jm.mu.Unlock()
+
+	j.log().Info("job started", "path", e.Path)
+	if err != nil {
+		j.log().Error("failed to persist job state", "err", err)
+		botMsg(j.Actor, fmt.Sprintf("job %d: failed to persist job state: %s", j.ID, err))
+	}
+	return j, ctx
+}
+
+// Wait makes j wait for the item c of journal entry e to reach target, and
+// report the outcome to e's channel. It returns a snapshot of j.
+func (jm *JobManager) Wait(j *Job, e JournalEntry, c cid.Cid, target api.TrackerStatus) Job {
+	jm.mu.Lock()
+	j.Cid = c.String()
+	j.Target = target.String()
+	j.State = JobWaiting
+	j.Deadline = time.Now().UTC().Add(jobTimeout)
+	j.Entry = e
+	err := jm.save()
+	snapshot := *j
+	jm.mu.Unlock()
+
+	snapshot.log().Info("job waiting", "target", snapshot.Target, "deadline", snapshot.Deadline)
+	if err != nil {
+		snapshot.log().Error("failed to persist job state", "err", err)
+		botMsg(j.Actor, fmt.Sprintf("job %d: failed to persist job state: %s", j.ID, err))
+	}
+
+	go jm.run(j)
+	return snapshot
+}
+
+// Resume starts watching every persisted waiting job again. Jobs that were
+// still running are reported as interrupted and dropped.
+func (jm *JobManager) Resume() int {
+	jm.mu.Lock()
+	var pending, interrupted []*Job
+	for _, j := range jm.jobs {
+		if j.State != JobWaiting {
+			interrupted = append(interrupted, j)
+			continue
+		}
+		j.ctx, j.cancel = context.WithCancel(context.Background())
+		pending = append(pending, j)
+	}
+	jm.mu.Unlock()
+
+	for _, j := range interrupted {
+		e := j.Entry
+		e.Time = time.Now().UTC()
+		e.Result, e.Error = ResultFailed, "interrupted by a restart"
+		logOp(j.Actor, &e)
+		botMsg(j.Actor, fmt.Sprintf("%s: job %d (%s %s) was interrupted by a restart, please try again", j.Nick, j.ID, j.Op, j.Entry.Path))
+		jm.Done(j)
+	}
+	for _, j := range pending {
+		go jm.run(j)
+	}
+	return len(pending)
+}
+
+func (jm *JobManager) run(j *Job) {
+	ctx, cancel := context.WithDeadline(j.ctx, j.Deadline)
+	defer cancel()
+
+	waitForClusterOp(ctx, j)
+	jm.Done(j)
+}
+
+// Done forgets about j.
+func (jm *JobManager) Done(j *Job) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	if j.cancel != nil {
+		j.cancel()
+	}
+	delete(jm.jobs, j.ID)
+	j.log().Debug("job done")
+	if err := jm.save(); err != nil {
+		j.log().Error("failed to persist job state", "err", err)
+		botMsg(j.Actor, fmt.Sprintf("job %d: failed to persist job state: %s", j.ID, err))
+	}
+}
+
+// List returns a snapshot of all unfinished jobs, oldest first.
+func (jm *JobManager) List() []Job {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	var list []Job
+	for _, j := range jm.jobs {
+		list = append(list, *j)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].ID < list[b].ID })
+	return list
+}
+
+// Cancel cancels the job with the given id. The job reports its own
+// cancelation once it notices.
+func (jm *JobManager) Cancel(id int) (Job, error) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	j, ok := jm.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("no such job: %d", id)
+	}
+	j.cancel()
+	return *j, nil
+}
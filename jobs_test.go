@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobManagerPersistence(t *testing.T) {
+	withQueues(t)
+	file := filepath.Join(t.TempDir(), "jobs.json")
+	jm := NewJobManager(file)
+	for _, path := range []string{"one", "two", "three"} {
+		jm.Start(&JournalEntry{Op: OpPin, Nick: "alice", Channel: "#pinbot", Path: path})
+	}
+	jm.Done(&Job{ID: 2})
+
+	loaded := NewJobManager(file)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	list := loaded.List()
+	if len(list) != 2 || list[0].ID != 1 || list[1].ID != 3 || list[1].Entry.Path != "three" {
+		t.Fatalf("loaded %v, want jobs 1 and 3", list)
+	}
+	// IDs are not handed out twice across restarts
+	j, _ := loaded.Start(&JournalEntry{Op: OpUnpin, Path: "four"})
+	if j.ID != 4 {
+		t.Errorf("first job after loading has ID %d, want 4", j.ID)
+	}
+
+	// after a checkpoint, jobs that finish stay in the file
+	if err := loaded.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	loaded.Done(j)
+	again := NewJobManager(file)
+	if err := again.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(again.List()); n != 3 {
+		t.Errorf("%d jobs in the file after the checkpoint, want 3", n)
+	}
+
+	if err := NewJobManager(filepath.Join(t.TempDir(), "nosuchfile")).Load(); err != nil {
+		t.Errorf("Load of a missing file: %s", err)
+	}
+}
+
+func TestJobManagerResume(t *testing.T) {
+	withQueues(t)
+	withJournal(t)
+	file := filepath.Join(t.TempDir(), "jobs.json")
+	deadline := time.Now().Add(time.Hour)
+	tests := []struct {
+		job     Job
+		resumed bool
+	}{
+		{Job{ID: 1, Op: OpPin, State: JobRunning, Entry: JournalEntry{ID: "a", Op: OpPin, Path: "one"}}, false},
+		// resumed, then given up on at once for its bad CID
+		{Job{ID: 2, Op: OpPin, State: JobWaiting, Cid: "notacid", Deadline: deadline, Entry: JournalEntry{ID: "b", Op: OpPin}}, true},
+	}
+	var jf jobsFile
+	jf.Next = 3
+	for i := range tests {
+		jf.Jobs = append(jf.Jobs, &tests[i].job)
+	}
+	buf, _ := json.Marshal(jf)
+	os.WriteFile(file, buf, 0660)
+
+	jm := NewJobManager(file)
+	if err := jm.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if n := jm.Resume(); n != 1 {
+		t.Errorf("Resume = %d, want 1", n)
+	}
+
+	timeout := time.Now().Add(5 * time.Second)
+	for len(jm.List()) > 0 && time.Now().Before(timeout) {
+		time.Sleep(time.Millisecond)
+	}
+	if list := jm.List(); len(list) > 0 {
+		t.Fatalf("jobs %v left after resuming", list)
+	}
+
+	var said []string
+	for _, m := range queued() {
+		said = append(said, m.message)
+	}
+	for _, tt := range tests {
+		want := "bad cid"
+		if !tt.resumed {
+			want = "interrupted by a restart"
+		}
+		found := false
+		for _, m := range said {
+			found = found || strings.Contains(m, want) && strings.Contains(m, fmt.Sprintf("job %d", tt.job.ID))
+		}
+		if !found {
+			t.Errorf("job %d: nothing said about %q in %q", tt.job.ID, want, said)
+		}
+	}
+
+	ops, err := journal.Ops()
+	if err != nil || len(ops) != 1 || ops[0].ID != "a" || ops[0].Result != ResultFailed {
+		t.Errorf("journal holds %+v, %v, want the interrupted pin as failed", ops, err)
+	}
+}
+
+func TestJobManagerCancel(t *testing.T) {
+	withQueues(t)
+	jm := NewJobManager(filepath.Join(t.TempDir(), "jobs.json"))
+	j, ctx := jm.Start(&JournalEntry{Op: OpPin, Path: "one"})
+
+	if _, err := jm.Cancel(j.ID + 1); err == nil {
+		t.Error("canceled a job that does not exist")
+	}
+	got, err := jm.Cancel(j.ID)
+	if err != nil || got.ID != j.ID {
+		t.Fatalf("Cancel = %v, %v, want job %d", got, err, j.ID)
+	}
+	select {
+	case <-ctx.Done():
+	default:
+		t.Error("the job's context was not canceled")
+	}
+	// the job reports the cancelation itself, and is forgotten only then
+	if n := len(jm.List()); n != 1 {
+		t.Errorf("%d jobs after canceling, want 1", n)
+	}
+}
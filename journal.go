@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// Journal operations.
+const (
+	OpPin     = "pin"
+	OpUnpin   = "unpin"
+	OpRecover = "recover"
+)
+
+// Journal results besides the tracker status an operation reached.
+const (
+	ResultSubmitted = "submitted"
+	ResultFailed    = "failed"
+	ResultTimeout   = "timeout"
+	ResultCanceled  = "canceled"
+)
+
+// JournalEntry records a pin, unpin or recover operation. An operation is
+// written once when it is submitted and again, under the same ID, whenever
+// its outcome changes.
+type JournalEntry struct {
+	ID       string       `json:"id"`
+	Time     time.Time    `json:"time"`
+	Op       string       `json:"op"`
+	Nick     string       `json:"nick,omitempty"`
+	Account  string       `json:"account,omitempty"`
+	Channel  string       `json:"channel,omitempty"`
+	Job      int          `json:"job,omitempty"`
+	Path     string       `json:"path"`
+	Cid      string       `json:"cid,omitempty"`
+	Label    string       `json:"label,omitempty"`
+	Options  *PinOpts     `json:"options,omitempty"`
+	Nodes    []NodeResult `json:"nodes,omitempty"`
+	Peers    []PeerResult `json:"peers,omitempty"`
+	Result   string       `json:"result,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Imported bool         `json:"imported,omitempty"`
+}
+
+// NodeResult is the outcome of a legacy pin or unpin on a single node.
+type NodeResult struct {
+	Node  string `json:"node"`
+	Error string `json:"error,omitempty"`
+}
+
+// PeerResult is the status of an item on a single cluster peer.
+type PeerResult struct {
+	Peer   string `json:"peer"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Journal is an append-only log of operations, one JSON object per line.
+type Journal struct {
+	mu   sync.Mutex
+	file string
+}
+
+var journal = &Journal{file: "pins.jsonl"}
+
+// legacyPinfile is the tab-separated log written by older versions.
+var legacyPinfile = "pins.log"
+
+func newEntryID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newJournalEntry starts the record of an operation requested by from in
+// channel.
+func newJournalEntry(op, channel string, from Sender, path, label string) *JournalEntry {
+	return &JournalEntry{
+		ID:      newEntryID(),
+		Time:    time.Now().UTC(),
+		Op:      op,
+		Nick:    from.Nick,
+		Account: from.account(),
+		Channel: channel,
+		Path:    path,
+		Label:   label,
+	}
+}
+
+// setPeers records the per-peer status in gpi.
+func (e *JournalEntry) setPeers(gpi *api.GlobalPinInfo) {
+	e.Peers = nil
+	for _, info := range gpi.PeerMap {
+		e.Peers = append(e.Peers, PeerResult{
+			Peer:   info.PeerName,
+			Status: info.Status.String(),
+			Error:  info.Error,
+		})
+	}
+}
+
+// Append writes e to the journal.
+func (j *Journal) Append(e *JournalEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	fi, err := os.OpenFile(j.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0660)
+	if err != nil {
+		return err
+	}
+
+	_, err = fi.Write(append(line, '\n'))
+	if err != nil {
+		fi.Close()
+		return err
+	}
+	return fi.Close()
+}
+
+// Read returns every record in the journal, oldest first.
+func (j *Journal) Read() ([]JournalEntry, error) {
+	j.mu.Lock()
+	buf, err := os.ReadFile(j.file)
+	j.mu.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []JournalEntry
+	for i, l := range bytes.Split(buf, []byte("\n")) {
+		if len(bytes.TrimSpace(l)) == 0 {
+			continue
+		}
+		var e JournalEntry
+		if err := json.Unmarshal(l, &e); err != nil {
+			return entries, fmt.Errorf("%s:%d: %s", j.file, i+1, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Ops returns the latest record of every operation in the journal, in the
+// order the operations were first submitted.
+func (j *Journal) Ops() ([]JournalEntry, error) {
+	entries, err := j.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int)
+	var ops []JournalEntry
+	for _, e := range entries {
+		if i, ok := index[e.ID]; ok {
+			ops[i] = e
+			continue
+		}
+		index[e.ID] = len(ops)
+		ops = append(ops, e)
+	}
+	return ops, nil
+}
+
+// ImportLegacy converts the pins in a legacy pins.log file into journal
+// records. It only does so when the journal does not exist yet, and returns
+// the number of pins imported. The records are written to a temporary file
+// that is renamed into place once all of them are in, so a failed import
+// is tried again on the next start. pins.log has no times, so every record
+// gets the time the file was last written.
+func (j *Journal) ImportLegacy(legacy string) (int, error) {
+	if _, err := os.Stat(j.file); !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	fi, err := os.Open(legacy)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer fi.Close()
+	st, err := fi.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var buf bytes.Buffer
+	var n int
+	scan := bufio.NewScanner(fi)
+	for scan.Scan() {
+		path, label, _ := strings.Cut(scan.Text(), "\t")
+		if path == "" {
+			continue
+		}
+
+		e := &JournalEntry{
+			ID:       newEntryID(),
+			Time:     st.ModTime().UTC(),
+			Op:       OpPin,
+			Path:     path,
+			Label:    label,
+			Imported: true,
+		}
+		if c, err := cid.Decode(strings.TrimPrefix(path, "/ipfs/")); err == nil {
+			e.Cid = c.String()
+		}
+
+		line, err := json.Marshal(e)
+		if err != nil {
+			return 0, err
+		}
+		buf.Write(append(line, '\n'))
+		n++
+	}
+	if err := scan.Err(); err != nil {
+		return 0, err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	tmp := j.file + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0660); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp, j.file)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// withJournal makes a journal in a temporary directory the journal for the
+// rest of the test.
+func withJournal(t *testing.T) *Journal {
+	old := journal
+	journal = &Journal{file: filepath.Join(t.TempDir(), "pins.jsonl")}
+	t.Cleanup(func() { journal = old })
+	return journal
+}
+
+func TestJournalOps(t *testing.T) {
+	j := withJournal(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	records := []JournalEntry{
+		{ID: "a", Time: now, Op: OpPin, Nick: "alice", Path: testCidV0, Label: "site", Result: ResultSubmitted},
+		{ID: "b", Time: now, Op: OpUnpin, Nick: "bob", Path: testCidV1},
+		{ID: "a", Time: now, Op: OpPin, Nick: "alice", Path: testCidV0, Label: "site", Cid: testCidV0, Result: "pinned",
+			Options: &PinOpts{ReplicationMin: 2}},
+	}
+	for i := range records {
+		if err := j.Append(&records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := j.Read()
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("Read = %d records, %v, want 3", len(entries), err)
+	}
+	ops, err := j.Ops()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		id, result, cid string
+	}{
+		// the latest record of a, in the place a was first submitted
+		{"a", "pinned", testCidV0},
+		{"b", "", ""},
+	}
+	if len(ops) != len(tests) {
+		t.Fatalf("Ops = %+v, want %d operations", ops, len(tests))
+	}
+	for i, tt := range tests {
+		e := ops[i]
+		if e.ID != tt.id || e.Result != tt.result || e.Cid != tt.cid || !e.Time.Equal(now) {
+			t.Errorf("operation %d = %s %s %s %s, want %s %s %s %s", i, e.ID, e.Result, e.Cid, e.Time, tt.id, tt.result, tt.cid, now)
+		}
+	}
+	if o := ops[0].Options; o == nil || o.ReplicationMin != 2 {
+		t.Errorf("options of a = %+v, want rmin 2", o)
+	}
+
+	os.WriteFile(j.file, []byte("{\"id\": \"a\"}\nnot json\n"), 0660)
+	if _, err := j.Read(); err == nil {
+		t.Error("Read of a corrupt journal succeeded")
+	}
+}
+
+func TestImportLegacy(t *testing.T) {
+	dir := t.TempDir()
+	legacy := filepath.Join(dir, "pins.log")
+	os.WriteFile(legacy, []byte(testCidV0+"\tmy site\n/ipfs/"+testCidV1+"\n\n/ipns/ipfs.io\tdocs\n"), 0660)
+	mtime := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(legacy, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	j := withJournal(t)
+	n, err := j.ImportLegacy(legacy)
+	if err != nil || n != 3 {
+		t.Fatalf("ImportLegacy = %d, %v, want 3", n, err)
+	}
+	if _, err := os.Stat(j.file + ".tmp"); !os.IsNotExist(err) {
+		t.Error("the temporary file was left behind")
+	}
+
+	ops, err := j.Ops()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path, label, cid string
+	}{
+		{testCidV0, "my site", testCidV0},
+		{"/ipfs/" + testCidV1, "", testCidV1},
+		{"/ipns/ipfs.io", "docs", ""},
+	}
+	if len(ops) != len(tests) {
+		t.Fatalf("imported %+v, want %d pins", ops, len(tests))
+	}
+	for i, tt := range tests {
+		e := ops[i]
+		if e.Op != OpPin || !e.Imported || e.Path != tt.path || e.Label != tt.label || e.Cid != tt.cid {
+			t.Errorf("pin %d = %+v, want %s %q %s", i, e, tt.path, tt.label, tt.cid)
+		}
+		if !e.Time.Equal(mtime) {
+			t.Errorf("pin %d was imported at %s, want the time of pins.log, %s", i, e.Time, mtime)
+		}
+	}
+
+	// pins are only ever imported into a journal that does not exist yet
+	if n, err := j.ImportLegacy(legacy); n != 0 || err != nil {
+		t.Errorf("second ImportLegacy = %d, %v, want 0", n, err)
+	}
+	if n, err := withJournal(t).ImportLegacy(filepath.Join(dir, "nosuchfile")); n != 0 || err != nil {
+		t.Errorf("ImportLegacy of a missing file = %d, %v, want 0", n, err)
+	}
+}
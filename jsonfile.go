@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// readJSONFile decodes the JSON in file into v. It reports false, and no
+// error, when the file does not exist yet.
+func readJSONFile(file string, v interface{}) (bool, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := json.Unmarshal(buf, v); err != nil {
+		return false, fmt.Errorf("%s: %s", file, err)
+	}
+	return true, nil
+}
+
+// writeJSONFileAtomic writes v to file as indented JSON. The JSON goes to a
+// temporary file that is then renamed over file, so a crash halfway leaves
+// the previous contents in place.
+func writeJSONFileAtomic(file string, v interface{}) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0660); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// labelName is what the names of labels look like.
+var labelName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// isPath reports whether s is a CID or an /ipfs/ or /ipns/ path rather than
+// a label.
+func isPath(s string) bool {
+	if strings.HasPrefix(s, "/") {
+		return true
+	}
+	_, err := cid.Decode(s)
+	return err == nil
+}
+
+// isLabel reports whether s can name a label.
+func isLabel(s string) bool {
+	return labelName.MatchString(s) && !isPath(s)
+}
+
+// Version is one CID a label has pointed to.
+type Version struct {
+	Cid  string    `json:"cid"`
+	Nick string    `json:"nick"`
+	Time time.Time `json:"time"`
+	Job  int       `json:"job,omitempty"`
+	// Options are the pin options the version was pinned with, used
+	// again when the label is rolled back to it.
+	Options *PinOpts `json:"options,omitempty"`
+	// RolledBack is set once the label has been rolled back past it.
+	RolledBack bool `json:"rolled_back,omitempty"`
+	// Failed is set when the pin of the version failed or timed out after
+	// it was submitted. Such a version is never current.
+	Failed bool `json:"failed,omitempty"`
+	// Note says more about the version, such as the release tag it was
+	// pinned for.
+	Note string `json:"note,omitempty"`
+}
+
+// Label is a name for whatever version of an item was pinned under it last.
+// Versions are kept oldest first; the current one is the newest that has not
+// been rolled back.
+type Label struct {
+	Name     string     `json:"name"`
+	Versions []*Version `json:"versions"`
+	// Unpinned is set when the current version has been unpinned.
+	Unpinned bool `json:"unpinned,omitempty"`
+}
+
+// current returns the index of the current version, or -1 if there is none.
+func (l *Label) current() int {
+	return l.previous(len(l.Versions))
+}
+
+// previous returns the index of the newest version before i that has neither
+// been rolled back nor failed, or -1 if there is none.
+func (l *Label) previous(i int) int {
+	for i--; i >= 0; i-- {
+		if !l.Versions[i].RolledBack && !l.Versions[i].Failed {
+			return i
+		}
+	}
+	return -1
+}
+
+// Labels holds the versions pinned under each label. It is persisted so that
+// they survive restarts.
+type Labels struct {
+	mu     sync.Mutex
+	file   string
+	labels map[string]*Label
+	// failed holds the jobs that failed before their version was added.
+	failed map[int]bool
+}
+
+var labels = NewLabels("labels.json")
+
+func NewLabels(file string) *Labels {
+	return &Labels{
+		file:   file,
+		labels: make(map[string]*Label),
+		failed: make(map[int]bool),
+	}
+}
+
+// Load reads the label history from file. When there is no file yet, the
+// history is rebuilt from the pins in the journal, so that labels pinned
+// before it was kept start out with their versions.
+func (ls *Labels) Load() error {
+	var list []*Label
+	found, err := readJSONFile(ls.file, &list)
+	if err != nil {
+		return err
+	}
+	if !found {
+		ops, err := journal.Ops()
+		if err != nil {
+			return err
+		}
+		n, err := ls.Import(ops)
+		if n > 0 {
+			logger.Info("imported label history from the journal", "versions", n)
+		}
+		return err
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	for _, l := range list {
+		ls.labels[l.Name] = l
+	}
+	return nil
+}
+
+// Import records the labeled pins among ops, oldest first, as versions of
+// their labels, and notes the unpins among them. It saves the history if
+// anything was recorded and returns the number of versions added.
+func (ls *Labels) Import(ops []JournalEntry) (int, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	var n int
+	for _, e := range ops {
+		if e.Cid == "" || e.Result == ResultFailed || e.Result == ResultTimeout {
+			continue
+		}
+		switch {
+		case e.Op == OpPin && isLabel(e.Label):
+			v := Version{Cid: e.Cid, Nick: e.Nick, Time: e.Time, Job: e.Job, Options: e.Options}
+			if _, added := ls.add(e.Label, v); added {
+				n++
+			}
+		case e.Op == OpUnpin:
+			ls.unpinned(e.Cid)
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, ls.save()
+}
+
+// save writes every label to file, sorted by name. It must be called with
+// mu held.
+func (ls *Labels) save() error {
+	list := make([]*Label, 0, len(ls.labels))
+	for _, l := range ls.labels {
+		list = append(list, l)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Name < list[b].Name })
+	return writeJSONFileAtomic(ls.file, list)
+}
+
+// Current returns the current version of name.
+func (ls *Labels) Current(name string) (Version, bool) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	l, ok := ls.labels[name]
+	if !ok {
+		return Version{}, false
+	}
+	i := l.current()
+	if i < 0 {
+		return Version{}, false
+	}
+	return *l.Versions[i], true
+}
+
+// IsUnpinned reports whether the current version of name has been unpinned.
+func (ls *Labels) IsUnpinned(name string) bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	l, ok := ls.labels[name]
+	return ok && l.Unpinned
+}
+
+// Add records v as the newest version of name and returns its number,
+// counting from 1. Pinning the current version again adds nothing.
+func (ls *Labels) Add(name string, v Version) (int, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	n, _ := ls.add(name, v)
+	return n, ls.save()
+}
+
+// add is Add without saving, and also reports whether v was new. It must be
+// called with mu held.
+func (ls *Labels) add(name string, v Version) (int, bool) {
+	l, ok := ls.labels[name]
+	if !ok {
+		l = &Label{Name: name}
+		ls.labels[name] = l
+	}
+	if v.Job != 0 && ls.failed[v.Job] {
+		delete(ls.failed, v.Job)
+		v.Failed = true
+	}
+	if i := l.current(); !v.Failed && i >= 0 && cidKey(l.Versions[i].Cid) == cidKey(v.Cid) {
+		l.Unpinned = false
+		return i + 1, false
+	}
+	if !v.Failed {
+		l.Unpinned = false
+	}
+	l.Versions = append(l.Versions, &v)
+	return len(l.Versions), true
+}
+
+// Previous returns the current version of name and the one a rollback
+// would return to.
+func (ls *Labels) Previous(name string) (cur, prev Version, err error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	l, ok := ls.labels[name]
+	if !ok {
+		return cur, prev, fmt.Errorf("there is no label %s", name)
+	}
+	i := l.current()
+	if i < 0 {
+		return cur, prev, fmt.Errorf("%s has no versions left", name)
+	}
+	p := l.previous(i)
+	if p < 0 {
+		return cur, prev, fmt.Errorf("%s has no version before %s", name, l.Versions[i].Cid)
+	}
+	return *l.Versions[i], *l.Versions[p], nil
+}
+
+// Rollback marks the current version of name as rolled back, provided it is
+// still c, and returns the number of the version that is current now.
+func (ls *Labels) Rollback(name, c string) (int, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	l, ok := ls.labels[name]
+	if !ok {
+		return 0, fmt.Errorf("there is no label %s", name)
+	}
+	i := l.current()
+	if i < 0 || cidKey(l.Versions[i].Cid) != cidKey(c) {
+		return 0, fmt.Errorf("%s has changed meanwhile", name)
+	}
+	l.Versions[i].RolledBack = true
+	l.Unpinned = false
+	return l.current() + 1, ls.save()
+}
+
+// Failed marks the version pinned by job as failed and returns the label it
+// was a version of, or "" if it was none. A job can fail before its version
+// is added, which then adds it as failed.
+func (ls *Labels) Failed(job int) (string, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	for _, l := range ls.labels {
+		for _, v := range l.Versions {
+			if v.Job == job && !v.Failed {
+				v.Failed = true
+				return l.Name, ls.save()
+			}
+		}
+	}
+	ls.failed[job] = true
+	return "", nil
+}
+
+// Unpinned notes that c has been unpinned, for every label whose current
+// version it is.
+func (ls *Labels) Unpinned(c string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if !ls.unpinned(c) {
+		return nil
+	}
+	return ls.save()
+}
+
+// unpinned is Unpinned without saving, and reports whether any label
+// changed. It must be called with mu held.
+func (ls *Labels) unpinned(c string) bool {
+	changed := false
+	for _, l := range ls.labels {
+		if i := l.current(); i >= 0 && cidKey(l.Versions[i].Cid) == cidKey(c) && !l.Unpinned {
+			l.Unpinned = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// History returns a copy of name's label.
+func (ls *Labels) History(name string) (Label, bool) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	l, ok := ls.labels[name]
+	if !ok {
+		return Label{}, false
+	}
+	out := Label{Name: l.Name, Unpinned: l.Unpinned}
+	for _, v := range l.Versions {
+		c := *v
+		out.Versions = append(out.Versions, &c)
+	}
+	return out, true
+}
+
+// labelPin picks the label name and the path out of the two ways of
+// pinning a version of a label, "!pin <label> <cid>" and the classic
+// "!pin <cid> <label>". It reports false when the label is free text that
+// cannot name a label.
+func labelPin(path, label string) (name, p string, ok bool) {
+	if isLabel(path) && isPath(label) {
+		return path, label, true
+	}
+	if isLabel(label) {
+		return label, path, true
+	}
+	return "", "", false
+}
+
+// PinLabeled pins path to cluster under label. When label can name a label,
+// in either order, the pin is recorded as the label's newest version.
+func PinLabeled(actor string, from Sender, path, label string, opts PinOpts) (Job, error) {
+	if name, p, ok := labelPin(path, label); ok {
+		return PinLabel(actor, from, name, p, opts, "")
+	}
+	return PinCluster(actor, from, path, label, opts)
+}
+
+// PinLabel pins path to cluster as the newest version of label name, noting
+// note with the version. Should the pin fail once submitted, the job drops
+// the version again with versionFailed.
+func PinLabel(actor string, from Sender, name, path string, opts PinOpts, note string) (Job, error) {
+	job, err := PinCluster(actor, from, path, name, opts)
+	if err != nil {
+		return job, err
+	}
+	v := Version{
+		Cid:  job.Cid,
+		Nick: from.Nick,
+		Time: time.Now().UTC(),
+		Job:  job.ID,
+		Note: note,
+	}
+	if !opts.IsZero() {
+		v.Options = &opts
+	}
+	n, err := labels.Add(name, v)
+	if err != nil {
+		botMsg(actor, fmt.Sprintf("failed to record %s as a version of %s: %s", job.Cid, name, err))
+		return job, nil
+	}
+	botMsg(actor, fmt.Sprintf("%s is now version %d of %s", job.Cid, n, name))
+	return job, nil
+}
+
+// versionFailed stops the version of a label pinned by job j, whose pin
+// failed or timed out, from being current.
+func versionFailed(j *Job) {
+	if j.Op != OpPin {
+		return
+	}
+	name, err := labels.Failed(j.ID)
+	if err != nil {
+		j.log().Error("failed to save the labels", "err", err)
+	}
+	if name == "" {
+		return
+	}
+	msg := fmt.Sprintf("%s: job %d: %s is not kept as a version of %s", j.Nick, j.ID, j.Cid, name)
+	if v, ok := labels.Current(name); ok {
+		msg += ", which stays at " + v.Cid
+	}
+	botMsg(j.Actor, msg)
+}
+
+// UnpinLabel unpins the current version of label name from cluster.
+func UnpinLabel(actor string, from Sender, name string) {
+	v, ok := labels.Current(name)
+	if !ok {
+		botMsg(actor, fmt.Sprintf("there is no label %s", name))
+		return
+	}
+	if labels.IsUnpinned(name) {
+		botMsg(actor, fmt.Sprintf("%s is already unpinned", name))
+		return
+	}
+	botMsg(actor, fmt.Sprintf("unpinning %s, version of %s pinned by %s", v.Cid, name, v.Nick))
+	UnpinCluster(actor, from, v.Cid)
+}
+
+// RollbackLabel pins the version of label name before the current one again,
+// with the options it was first pinned with, and makes it current. With
+// unpin, the version rolled back from is unpinned.
+func RollbackLabel(actor string, from Sender, name string, unpin bool) {
+	cur, prev, err := labels.Previous(name)
+	if err != nil {
+		botMsg(actor, fmt.Sprintf("cannot roll back: %s", err))
+		return
+	}
+	var opts PinOpts
+	if prev.Options != nil {
+		opts = *prev.Options
+	}
+	if !opts.ExpireAt.IsZero() && opts.ExpireAt.Before(time.Now()) {
+		botMsg(actor, fmt.Sprintf("cannot roll back: %s expired %s", prev.Cid, opts.ExpireAt.Format("2006-01-02 15:04")))
+		return
+	}
+	if err := opts.Allowed(from); err != nil {
+		botMsg(actor, fmt.Sprintf("cannot roll back: %s", err))
+		return
+	}
+	botMsg(actor, fmt.Sprintf("rolling %s back from %s to %s", name, cur.Cid, prev.Cid))
+	if _, err := PinCluster(actor, from, prev.Cid, name, opts); err != nil {
+		return
+	}
+	n, err := labels.Rollback(name, cur.Cid)
+	if err != nil {
+		botMsg(actor, fmt.Sprintf("failed to roll back %s: %s", name, err))
+		return
+	}
+	botMsg(actor, fmt.Sprintf("%s is back at version %d, %s", name, n, prev.Cid))
+
+	if unpin && cidKey(cur.Cid) != cidKey(prev.Cid) {
+		UnpinCluster(actor, from, cur.Cid)
+	}
+}
+
+// HistoryCmd lists the versions of label name to actor, newest first.
+func HistoryCmd(actor, name string) {
+	l, ok := labels.History(name)
+	if !ok {
+		botMsg(actor, fmt.Sprintf("there is no label %s; try %s%s %s", name, prefix, cmdPins, name))
+		return
+	}
+	cur := l.current()
+	lines := make([]string, 0, len(l.Versions))
+	for i := len(l.Versions) - 1; i >= 0; i-- {
+		v := l.Versions[i]
+		line := fmt.Sprintf("v%d %s %s by %s", i+1, v.Time.Format("2006-01-02 15:04"), v.Cid, v.Nick)
+		if v.Note != "" {
+			line += " " + v.Note
+		}
+		switch {
+		case i == cur && l.Unpinned:
+			line += " (current, unpinned)"
+		case i == cur:
+			line += " (current)"
+		case v.RolledBack:
+			line += " (rolled back)"
+		case v.Failed:
+			line += " (failed)"
+		}
+		lines = append(lines, line)
+	}
+	sendLong(actor, fmt.Sprintf("history of %s", name), lines)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLabelPin(t *testing.T) {
+	tests := []struct {
+		path, label string
+		name, p     string
+		ok          bool
+	}{
+		{path: "website", label: testCidV0, name: "website", p: testCidV0, ok: true},
+		{path: testCidV0, label: "website", name: "website", p: testCidV0, ok: true},
+		{path: "/ipns/ipfs.io", label: "website", name: "website", p: "/ipns/ipfs.io", ok: true},
+		{path: testCidV0, label: "my site"},
+		{path: testCidV0, label: "ipfs/website v1.4.0"},
+		{path: testCidV0, label: testCidV1},
+	}
+	for _, tt := range tests {
+		name, p, ok := labelPin(tt.path, tt.label)
+		if name != tt.name || p != tt.p || ok != tt.ok {
+			t.Errorf("labelPin(%q, %q) = %q, %q, %v, want %q, %q, %v",
+				tt.path, tt.label, name, p, ok, tt.name, tt.p, tt.ok)
+		}
+	}
+}
+
+func TestLabelsVersions(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "labels.json")
+	ls := NewLabels(file)
+	add := func(c string, want int) {
+		t.Helper()
+		n, err := ls.Add("site", Version{Cid: c, Nick: "alice"})
+		if err != nil || n != want {
+			t.Fatalf("Add(%s) = %d, %v, want %d", c, n, err, want)
+		}
+	}
+	add("one", 1)
+	add("two", 2)
+	// pinning the current version again, in either CID form, adds nothing
+	add(testCidV0, 3)
+	add(testCidV1, 3)
+
+	if _, _, err := ls.Previous("nosuchlabel"); err == nil {
+		t.Error("Previous of an unknown label: want an error")
+	}
+	cur, prev, err := ls.Previous("site")
+	if err != nil || cur.Cid != testCidV0 || prev.Cid != "two" {
+		t.Fatalf("Previous = %s, %s, %v, want %s, two", cur.Cid, prev.Cid, err, testCidV0)
+	}
+	if _, err := ls.Rollback("site", "two"); err == nil {
+		t.Error("rolled back from a version that is not current")
+	}
+	if n, err := ls.Rollback("site", testCidV1); err != nil || n != 2 {
+		t.Fatalf("Rollback = %d, %v, want 2", n, err)
+	}
+	if v, _ := ls.Current("site"); v.Cid != "two" {
+		t.Errorf("current is %s after rolling back, want two", v.Cid)
+	}
+
+	if err := ls.Unpinned("one"); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := ls.History("site"); l.Unpinned {
+		t.Error("unpinning an old version marked the label unpinned")
+	}
+	if err := ls.Unpinned("two"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewLabels(file)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	l, ok := loaded.History("site")
+	if !ok || len(l.Versions) != 3 || !l.Unpinned || !l.Versions[2].RolledBack || l.current() != 1 {
+		t.Errorf("loaded %+v, want three versions, the last rolled back, and two unpinned", l)
+	}
+
+	// a new version is pinned again
+	add("four", 4)
+	if l, _ := ls.History("site"); l.Unpinned || l.current() != 3 {
+		t.Errorf("after adding: %+v, want version 4 current and pinned", l)
+	}
+}
+
+func TestLabelsImport(t *testing.T) {
+	withJournal(t)
+
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	ttl := &PinOpts{ReplicationMin: 2, TTL: Duration{30 * day}}
+	entries := []JournalEntry{
+		{ID: "a", Op: OpPin, Cid: "one", Label: "site", Nick: "alice", Result: ResultSubmitted},
+		{ID: "a", Op: OpPin, Cid: "one", Label: "site", Nick: "alice", Result: "pinned"},
+		{ID: "b", Op: OpPin, Cid: "two", Label: "site", Nick: "bob", Job: 7, Options: ttl, Result: "pinned"},
+		{ID: "c", Op: OpPin, Cid: "three", Label: "site", Result: ResultFailed},
+		{ID: "d", Op: OpPin, Cid: "free", Label: "my site", Result: "pinned"},
+		{ID: "e", Op: OpPin, Cid: "other", Label: "docs", Result: "pinned"},
+		{ID: "f", Op: OpUnpin, Cid: "other", Result: "unpinned"},
+		{ID: "g", Op: OpPin, Path: "/ipns/nowhere", Label: "blog", Result: ResultFailed},
+		{ID: "h", Op: OpPin, Cid: "five", Label: "site", Result: ResultTimeout},
+	}
+	for i, e := range entries {
+		e.Time = start.Add(time.Duration(i) * time.Hour)
+		if err := journal.Append(&e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "labels.json")
+	ls := NewLabels(file)
+	if err := ls.Load(); err != nil {
+		t.Fatal(err)
+	}
+	site, ok := ls.History("site")
+	if !ok || len(site.Versions) != 2 {
+		t.Fatalf("site has %+v, want two versions", site)
+	}
+	v := site.Versions[1]
+	if v.Cid != "two" || v.Nick != "bob" || v.Job != 7 || v.Options == nil || v.Options.ReplicationMin != 2 {
+		t.Errorf("version 2 of site is %+v, want two by bob with its options", v)
+	}
+	if !site.Versions[0].Time.Equal(start.Add(time.Hour)) {
+		t.Errorf("version 1 of site was pinned %s, want %s", site.Versions[0].Time, start.Add(time.Hour))
+	}
+	if docs, _ := ls.History("docs"); !docs.Unpinned {
+		t.Errorf("docs is %+v, want it unpinned", docs)
+	}
+	for _, name := range []string{"my site", "blog"} {
+		if _, ok := ls.History(name); ok {
+			t.Errorf("imported %s", name)
+		}
+	}
+
+	// once saved, the file is what is loaded and the journal is not read
+	// again
+	if _, err := ls.Add("site", Version{Cid: "four"}); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewLabels(file)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if site, _ := loaded.History("site"); len(site.Versions) != 3 {
+		t.Errorf("loaded %+v, want three versions of site", site)
+	}
+}
+
+func TestLabelsFailed(t *testing.T) {
+	ls := NewLabels(filepath.Join(t.TempDir(), "labels.json"))
+	steps := []struct {
+		step    func() error
+		current string
+	}{
+		{func() error { _, err := ls.Add("site", Version{Cid: "one", Job: 1}); return err }, "one"},
+		{func() error { _, err := ls.Add("site", Version{Cid: "two", Job: 2}); return err }, "two"},
+		// the pin of two failed after it was submitted
+		{func() error { _, err := ls.Failed(2); return err }, "one"},
+		// job 4 fails before its version is added
+		{func() error { _, err := ls.Failed(4); return err }, "one"},
+		{func() error { _, err := ls.Add("site", Version{Cid: "three", Job: 4}); return err }, "one"},
+		// pinning two again makes it current after all
+		{func() error { _, err := ls.Add("site", Version{Cid: "two", Job: 5}); return err }, "two"},
+	}
+	for i, tt := range steps {
+		if err := tt.step(); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if v, _ := ls.Current("site"); v.Cid != tt.current {
+			t.Errorf("%d: current is %s, want %s", i, v.Cid, tt.current)
+		}
+	}
+
+	l, _ := ls.History("site")
+	var failed []int
+	for _, v := range l.Versions {
+		if v.Failed {
+			failed = append(failed, v.Job)
+		}
+	}
+	if len(l.Versions) != 4 || len(failed) != 2 || failed[0] != 2 || failed[1] != 4 {
+		t.Errorf("site has %d versions, jobs %v failed, want 4 versions and jobs 2 and 4 failed", len(l.Versions), failed)
+	}
+	if _, prev, err := ls.Previous("site"); err != nil || prev.Cid != "one" {
+		t.Errorf("Previous = %s, %v, want to roll back past the failed versions to one", prev.Cid, err)
+	}
+	if name, err := ls.Failed(2); name != "" || err != nil {
+		t.Errorf("failing job 2 again = %q, %v, want nothing", name, err)
+	}
+}
+
+func TestUnpinLabel(t *testing.T) {
+	withQueues(t)
+	old := labels
+	labels = NewLabels(filepath.Join(t.TempDir(), "labels.json"))
+	t.Cleanup(func() { labels = old })
+	if _, err := labels.Add("site", Version{Cid: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := labels.Unpinned("one"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, want string
+	}{
+		{"site", "site is already unpinned"},
+		{"docs", "there is no label docs"},
+	}
+	for _, tt := range tests {
+		UnpinLabel("#pinbot", Sender{Nick: "alice"}, tt.name)
+		m := queued()
+		if len(m) != 1 || m[0].message != tt.want {
+			t.Errorf("UnpinLabel(%s) said %v, want %q", tt.name, m, tt.want)
+		}
+	}
+}
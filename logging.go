@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// logger is the application log. Everything pinbot logs goes through it,
+// with context fields rather than prose where it can.
+var logger = log.New()
+
+// ircLog is where the bot library logs the IRC protocol. It is kept apart
+// from the application log because it is far more verbose.
+var ircLog = log.DiscardHandler()
+
+// LogConfig configures the application log and, separately, the IRC
+// protocol log, which is off unless IRC is set.
+type LogConfig struct {
+	LogOutput
+	// IRC fields left empty default to debug level and the format and
+	// output of the application log.
+	IRC *LogOutput `json:"irc,omitempty"`
+}
+
+// irc returns the settings of the IRC protocol log with defaults filled
+// in. It must only be called when IRC is set.
+func (cfg LogConfig) irc() LogOutput {
+	o := *cfg.IRC
+	if o.Level == "" {
+		o.Level = "debug"
+	}
+	if o.Format == "" {
+		o.Format = cfg.Format
+	}
+	if o.Output == "" {
+		o.Output = cfg.Output
+	}
+	return o
+}
+
+// validate returns the problems with cfg.
+func (cfg LogConfig) validate() []error {
+	errs := cfg.LogOutput.validate("log")
+	if cfg.IRC != nil {
+		errs = append(errs, cfg.irc().validate("log.irc")...)
+	}
+	return errs
+}
+
+// LogOutput says which records go where and how they are written. Output
+// is stdout, stderr or the path of a file to append to.
+type LogOutput struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+	Output string `json:"output"`
+}
+
+var logFormats = map[string]func() log.Format{
+	"logfmt":   log.LogfmtFormat,
+	"json":     log.JsonFormat,
+	"terminal": log.TerminalFormat,
+}
+
+// validate returns the problems with o, prefixing each with field.
+func (o LogOutput) validate(field string) []error {
+	var errs []error
+	if _, err := log.LvlFromString(o.Level); err != nil {
+		errs = append(errs, fmt.Errorf("%s.level: unknown level %q", field, o.Level))
+	}
+	if _, ok := logFormats[o.Format]; !ok {
+		errs = append(errs, fmt.Errorf("%s.format: must be logfmt, json or terminal", field))
+	}
+	if o.Output == "" {
+		errs = append(errs, fmt.Errorf("%s.output: must be set", field))
+	}
+	return errs
+}
+
+// handler returns a handler writing o's records, or an error if its output
+// cannot be opened.
+func (o LogOutput) handler() (log.Handler, error) {
+	lvl, err := log.LvlFromString(o.Level)
+	if err != nil {
+		return nil, err
+	}
+	format, ok := logFormats[o.Format]
+	if !ok {
+		return nil, fmt.Errorf("unknown log format %q", o.Format)
+	}
+
+	var h log.Handler
+	switch o.Output {
+	case "stdout":
+		h = log.StreamHandler(os.Stdout, format())
+	case "stderr":
+		h = log.StreamHandler(os.Stderr, format())
+	default:
+		h, err = log.FileHandler(o.Output, format())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return log.LvlFilterHandler(lvl, h), nil
+}
+
+// setupLogging points logger and ircLog at the outputs in cfg.
+func setupLogging(cfg LogConfig) error {
+	h, err := cfg.handler()
+	if err != nil {
+		return fmt.Errorf("log: %s", err)
+	}
+	irc := log.DiscardHandler()
+	if cfg.IRC != nil {
+		irc, err = cfg.irc().handler()
+		if err != nil {
+			return fmt.Errorf("log.irc: %s", err)
+		}
+	}
+	logger.SetHandler(h)
+	ircLog = irc
+	return nil
+}
@@ -1,15 +1,18 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"maps"
 	"math/rand"
+	"net"
 	"os"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	cid "github.com/ipfs/go-cid"
@@ -27,11 +30,14 @@ var gateway string
 var bot *hb.Bot
 var msgs chan msgWrap
 
-var retries = 5
-
 type msgWrap struct {
 	message string
 	actor   string
+	// notice sends the message as a notice.
+	notice bool
+	// flushed, when set, is closed once every message queued before it
+	// has been sent.
+	flushed chan struct{}
 }
 
 var (
@@ -46,6 +52,16 @@ var (
 	cmdRecover     = "recover"
 	cmdPinLegacy   = "legacypin"
 	cmdUnpinLegacy = "legacyunpin"
+	cmdPins        = "pins"
+	cmdWhois       = "whois"
+	cmdJobs        = "jobs"
+	cmdCancel      = "cancel"
+	cmdReload      = "reload"
+	cmdMore        = "more"
+	cmdExtend      = "extend"
+	cmdExpiring    = "expiring"
+	cmdHistory     = "history"
+	cmdRollback    = "rollback"
 )
 
 var (
@@ -57,28 +73,42 @@ func init() {
 	r = rand.New(rand.NewSource(time.Now().UTC().UnixNano()))
 }
 
-func messageQueueProcess() {
-	for mWrap := range msgs {
-		sendMsg(mWrap.actor, mWrap.message)
+func botMsg(actor, msg string) {
+	logger.Debug("queued message", "channel", actor, "msg", msg)
+	msgs <- msgWrap{
+		message: msg,
+		actor:   actor,
 	}
 }
 
-func botMsg(actor, msg string) {
+// botNotice queues msg for actor as a notice, which bots are not supposed to
+// answer.
+func botNotice(actor, msg string) {
+	logger.Debug("queued notice", "channel", actor, "msg", msg)
 	msgs <- msgWrap{
 		message: msg,
 		actor:   actor,
+		notice:  true,
 	}
 }
 
-func sendMsg(actor, msg string) {
+func sendMsg(actor, msg string, notice bool) {
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Println(r)
-			fmt.Println("recovered from panic, sleeping a bit")
+			logger.Error("recovered from panic sending a message, sleeping a bit", "channel", actor, "panic", r)
 			time.Sleep(10 * time.Second)
 		}
 	}()
-	bot.Msg(actor, msg)
+	t, channel, err := resolve(actor)
+	if err != nil {
+		logger.Error("cannot deliver message", "channel", actor, "err", err)
+		return
+	}
+	if notice {
+		t.Notice(channel, msg)
+		return
+	}
+	t.Msg(channel, msg)
 }
 
 func formatError(action string, err error) error {
@@ -92,17 +122,20 @@ func formatError(action string, err error) error {
 	return fmt.Errorf("%s failed: %s", action, err.Error())
 }
 
-func tryPin(path string, sh *shell.Shell) error {
-	out, err := sh.Refs(path, true)
+func tryPin(ctx context.Context, path string, sh *shell.Shell) error {
+	resp, err := sh.Request("refs", path).Option("recursive", true).Send(ctx)
 	if err != nil {
 		return formatError("refs", err)
 	}
+	if resp.Error != nil {
+		resp.Close()
+		return formatError("refs", resp.Error)
+	}
 
 	// throw away results
-	for range out {
-	}
+	resp.Close()
 
-	err = sh.Pin(path)
+	err = sh.Request("pin/add", path).Option("recursive", true).Exec(ctx, nil)
 	if err != nil {
 		return formatError("pin", err)
 	}
@@ -110,17 +143,20 @@ func tryPin(path string, sh *shell.Shell) error {
 	return nil
 }
 
-func tryUnpin(path string, sh *shell.Shell) error {
-	out, err := sh.Refs(path, true)
+func tryUnpin(ctx context.Context, path string, sh *shell.Shell) error {
+	resp, err := sh.Request("refs", path).Option("recursive", true).Send(ctx)
 	if err != nil {
 		return formatError("refs", err)
 	}
+	if resp.Error != nil {
+		resp.Close()
+		return formatError("refs", resp.Error)
+	}
 
 	// throw away results
-	for range out {
-	}
+	resp.Close()
 
-	err = sh.Unpin(path)
+	err = sh.Request("pin/rm", path).Option("recursive", true).Exec(ctx, nil)
 	if err != nil {
 		return formatError("unpin", err)
 	}
@@ -128,188 +164,429 @@ func tryUnpin(path string, sh *shell.Shell) error {
 	return nil
 }
 
-var pinfile = "pins.log"
-
-func writePin(pin, label string) error {
-	fi, err := os.OpenFile(pinfile, os.O_APPEND|os.O_EXCL|os.O_WRONLY, 0660)
-	if err != nil {
-		return err
-	}
-
-	_, err = fmt.Fprintf(fi, "%s\t%s\n", pin, label)
-	if err != nil {
-		return err
-	}
-	return fi.Close()
-}
-
-func Pin(b *hb.Bot, actor, path, label string) {
+func Pin(actor string, from Sender, path, label string) {
 	if !strings.HasPrefix(path, "/ipfs") && !strings.HasPrefix(path, "/ipns") {
 		path = "/ipfs/" + path
 	}
 
-	errs := make(chan error, len(shs))
+	e := newJournalEntry(OpPin, actor, from, path, label)
+	j, ctx := jobs.Start(e)
+	cl := clients.Load()
+	results := make(chan NodeResult, len(cl.shs))
 	var wg sync.WaitGroup
 
-	botMsg(actor, fmt.Sprintf("now pinning on %d nodes", len(shs)))
+	botMsg(actor, fmt.Sprintf("job %d: now pinning on %d nodes", j.ID, len(cl.shs)))
 
 	// pin to every node concurrently.
-	for i, sh := range shs {
+	for i, sh := range cl.shs {
 		wg.Add(1)
 		go func(i int, sh *shell.Shell) {
 			defer wg.Done()
-			if err := tryPin(path, sh); err != nil {
-				errs <- fmt.Errorf("%s -- %s", shsUrls[i], err)
+			res := NodeResult{Node: cl.shsUrls[i]}
+			start := time.Now()
+			if err := tryPin(ctx, path, sh); err != nil {
+				res.Error = err.Error()
 			}
+			tryPinSeconds.Observe(time.Since(start).Seconds(), cl.shsPeers[i])
+			results <- res
 		}(i, sh)
 	}
 
-	// close the err chan when done.
+	// close the results chan when done.
 	go func() {
 		wg.Wait()
-		close(errs)
+		close(results)
 	}()
 
-	// wait on the err chan and print every err we get as we get it.
+	// wait on the results chan and print every err we get as we get it.
 	var failed int
-	for err := range errs {
-		botMsg(actor, err.Error())
-		failed++
+	for res := range results {
+		e.Nodes = append(e.Nodes, res)
+		if res.Error != "" {
+			if ctx.Err() == nil {
+				botMsg(actor, fmt.Sprintf("%s -- %s", res.Node, res.Error))
+			}
+			failed++
+		}
+	}
+
+	if jobCanceled(ctx, actor, j, e) {
+		return
 	}
 
-	successes := len(shs) - failed
+	successes := len(cl.shs) - failed
 	botMsg(actor, fmt.Sprintf("pinned on %d of %d nodes (%d failures) -- %s%s",
-		successes, len(shs), failed, gateway, path))
+		successes, len(cl.shs), failed, gateway, path))
 
-	if err := writePin(path, label); err != nil {
-		botMsg(actor, fmt.Sprintf("failed to write log entry for last pin: %s", err))
-	}
-	clusterPinUnpin(b, actor, path, label, true)
+	clusterPinUnpin(ctx, actor, e, j, true)
 }
 
-func Unpin(b *hb.Bot, actor, path string) {
+func Unpin(actor string, from Sender, path string) {
 	if !strings.HasPrefix(path, "/ipfs") && !strings.HasPrefix(path, "/ipns") {
 		path = "/ipfs/" + path
 	}
 
-	errs := make(chan error, len(shs))
+	e := newJournalEntry(OpUnpin, actor, from, path, "")
+	j, ctx := jobs.Start(e)
+	cl := clients.Load()
+	results := make(chan NodeResult, len(cl.shs))
 	var wg sync.WaitGroup
 
-	botMsg(actor, fmt.Sprintf("now unpinning on %d nodes", len(shs)))
+	botMsg(actor, fmt.Sprintf("job %d: now unpinning on %d nodes", j.ID, len(cl.shs)))
 
 	// pin to every node concurrently.
-	for i, sh := range shs {
+	for i, sh := range cl.shs {
 		wg.Add(1)
 		go func(i int, sh *shell.Shell) {
 			defer wg.Done()
-			if err := tryUnpin(path, sh); err != nil {
-				errs <- fmt.Errorf("%s -- %s", shsUrls[i], err)
+			res := NodeResult{Node: cl.shsUrls[i]}
+			if err := tryUnpin(ctx, path, sh); err != nil {
+				res.Error = err.Error()
 			}
+			results <- res
 		}(i, sh)
 	}
 
-	// close the err chan when done.
+	// close the results chan when done.
 	go func() {
 		wg.Wait()
-		close(errs)
+		close(results)
 	}()
 
-	// wait on the err chan and print every err we get as we get it.
+	// wait on the results chan and print every err we get as we get it.
 	var failed int
-	for err := range errs {
-		botMsg(actor, err.Error())
-		failed++
+	for res := range results {
+		e.Nodes = append(e.Nodes, res)
+		if res.Error != "" {
+			if ctx.Err() == nil {
+				botMsg(actor, fmt.Sprintf("%s -- %s", res.Node, res.Error))
+			}
+			failed++
+		}
+	}
+
+	if jobCanceled(ctx, actor, j, e) {
+		return
 	}
 
-	successes := len(shs) - failed
+	successes := len(cl.shs) - failed
 	botMsg(actor, fmt.Sprintf("unpinned on %d of %d nodes (%d failures) -- %s%s",
-		successes, len(shs), failed, gateway, path))
-	clusterPinUnpin(b, actor, path, "", false)
+		successes, len(cl.shs), failed, gateway, path))
+	clusterPinUnpin(ctx, actor, e, j, false)
 }
 
-// StatusCluster gets cluster status of cid with given path.
-func StatusCluster(b *hb.Bot, actor, path string) {
-	ctx := context.Background()
+// errJobCanceled is returned for jobs canceled before their operation was
+// submitted.
+var errJobCanceled = errors.New("canceled")
+
+// jobCanceled reports whether running job j has been canceled, in which
+// case it journals and announces the cancelation and forgets the job.
+func jobCanceled(ctx context.Context, actor string, j *Job, e *JournalEntry) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	e.Result = ResultCanceled
+	logOp(actor, e)
+	botMsg(actor, fmt.Sprintf("job %d: canceled", j.ID))
+	jobs.Done(j)
+	return true
+}
+
+// StatusCluster gets cluster status of cid with given path, summarized on
+// one line unless full is set.
+func StatusCluster(actor, path string, full bool) {
+	st, err := clusterStatus(context.Background(), path)
+	if err != nil {
+		botMsg(actor, err.Error())
+		return
+	}
+	who := requester(context.Background(), st.Cid)
+	if !full {
+		summary := summarizeStatus(st)
+		if who != "" {
+			summary += " -- " + who
+		}
+		botMsg(actor, summary)
+		return
+	}
+	lines := statusLines(st)
+	if who != "" {
+		lines = slices.Insert(lines, 1, "  "+who)
+	}
+	sendLong(actor, fmt.Sprintf("status of %s", st.Cid), lines)
+}
+
+// clusterStatus resolves path and returns the cluster status of the
+// resulting cid.
+func clusterStatus(ctx context.Context, path string) (*api.GlobalPinInfo, error) {
+	cl := clients.Load()
 
 	// pick up a random shell
-	shell := shs[r.Intn(len(shs))]
+	shell := cl.randomShell()
 
 	c, err := resolveCid(path, shell)
 	if err != nil {
-		botMsg(actor, fmt.Sprintf("could not resolve cid: %s", err))
-		return
+		return nil, fmt.Errorf("could not resolve cid: %s", err)
 	}
 
-	st, err := lbClient.Status(ctx, c, false)
+	st, err := cl.lbClient.Status(ctx, c, false)
 	if err != nil {
-		botMsg(actor, fmt.Sprintf("error obtaining pin status: %s", err))
-		return
+		return nil, fmt.Errorf("error obtaining pin status: %s", err)
 	}
-	prettyClusterStatus(actor, st)
+	return st, nil
 }
 
 // StatusAllCluster gets status of all items in cluster matching the given
-// filter.
-func StatusAllCluster(b *hb.Bot, actor string, filter api.TrackerStatus) {
+// filter, one line per item unless full is set, a page at a time.
+func StatusAllCluster(actor string, filter api.TrackerStatus, full bool) {
 	ctx := context.Background()
-	sts, err := lbClient.StatusAll(ctx, filter, false)
+	sts, err := clients.Load().lbClient.StatusAll(ctx, filter, false)
 	if err != nil {
 		botMsg(actor, fmt.Sprintf("error obtaining pin statuses: %s", err))
 		return
 	}
+	if filter&api.TrackerStatusError != 0 {
+		countPeerErrors(sts)
+	}
+	if len(sts) == 0 {
+		botMsg(actor, "nothing to report")
+		return
+	}
+
+	var lines []string
 	for _, st := range sts {
-		prettyClusterStatus(actor, st)
-		time.Sleep(5 * time.Second) // flood prevention
+		if full {
+			lines = append(lines, statusLines(st)...)
+		} else {
+			lines = append(lines, summarizeStatus(st))
+		}
 	}
+	sendLong(actor, fmt.Sprintf("status of %d items", len(sts)), lines)
 }
 
-func prettyClusterStatus(actor string, st *api.GlobalPinInfo) {
-	botMsg(actor, fmt.Sprintf("Status for %s:", st.Cid))
+// statusLines breaks the status of an item down by peer.
+func statusLines(st *api.GlobalPinInfo) []string {
+	lines := []string{fmt.Sprintf("Status for %s:", st.Cid)}
+	for _, id := range slices.Sorted(maps.Keys(st.PeerMap)) {
+		info := st.PeerMap[id]
+		lines = append(lines, fmt.Sprintf("  - %s : %s | %s", info.PeerName, info.Status, info.Error))
+	}
+	return lines
+}
+
+// maxSummaryErrors is how many peer errors a status summary spells out.
+const maxSummaryErrors = 2
+
+// summarizeStatus describes the status of an item on one line, such as
+// "QmX: pinned 7/9, pinning 1, error 1 (peerA: context deadline)".
+func summarizeStatus(st *api.GlobalPinInfo) string {
+	counts := make(map[string]int)
+	var errs []string
 	for _, info := range st.PeerMap {
-		botMsg(actor, fmt.Sprintf("  - %s : %s | %s\n", info.PeerName, info.Status, info.Error))
+		counts[info.Status.String()]++
+		if info.Error != "" {
+			errs = append(errs, fmt.Sprintf("%s: %s", info.PeerName, info.Error))
+		}
+	}
+
+	statuses := slices.Collect(maps.Keys(counts))
+	slices.SortFunc(statuses, func(a, b string) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return strings.Compare(a, b)
+	})
+
+	parts := make([]string, len(statuses))
+	for i, s := range statuses {
+		parts[i] = fmt.Sprintf("%s %d", s, counts[s])
+	}
+	if len(parts) > 0 {
+		parts[0] += fmt.Sprintf("/%d", len(st.PeerMap))
+	}
+	out := fmt.Sprintf("%s: %s", st.Cid, strings.Join(parts, ", "))
+	if len(parts) == 0 {
+		out += "no peers"
+	}
+
+	if len(errs) > 0 {
+		slices.Sort(errs)
+		shown := errs[:min(len(errs), maxSummaryErrors)]
+		out += " (" + strings.Join(shown, "; ")
+		if more := len(errs) - len(shown); more > 0 {
+			out += fmt.Sprintf("; %d more", more)
+		}
+		out += ")"
 	}
+	return out
 }
 
-// PinCluster pins the item with given path to cluster.
-func PinCluster(b *hb.Bot, actor, path, label string) {
-	clusterPinUnpin(b, actor, path, label, true)
-	if err := writePin(path, label); err != nil {
-		botMsg(actor, fmt.Sprintf("failed to write log entry for last pin: %s", err))
+// PinCluster pins the item with given path to cluster, with the cluster's
+// defaults for anything opts leaves unset. It returns the job watching the
+// pin once it has been submitted.
+func PinCluster(actor string, from Sender, path, label string, opts PinOpts) (Job, error) {
+	e := newJournalEntry(OpPin, actor, from, path, label)
+	if !opts.IsZero() {
+		e.Options = &opts
+	}
+	j, ctx := jobs.Start(e)
+	job, err := clusterPinUnpin(ctx, actor, e, j, true)
+	if err != nil {
+		return job, err
+	}
+	if opts.TTL.Duration == 0 {
+		// pinned for good, so an earlier expiry no longer applies
+		if removed, err := schedule.Remove(job.Cid); err != nil {
+			entryLogger(e).Error("failed to save the expiry schedule", "err", err)
+		} else if removed {
+			botMsg(actor, fmt.Sprintf("%s will no longer expire", job.Cid))
+		}
+		return job, nil
+	}
+
+	x := Expiry{
+		Cid:     job.Cid,
+		Label:   label,
+		Nick:    from.Nick,
+		Actor:   actor,
+		Expires: time.Now().Add(opts.TTL.Duration).UTC(),
+	}
+	if err := schedule.Add(x); err != nil {
+		botMsg(actor, fmt.Sprintf("failed to schedule the expiry of %s: %s", x.Cid, err))
+		return job, nil
 	}
+	botMsg(actor, fmt.Sprintf("%s will be unpinned %s", x.Cid, x.Expires.Format("2006-01-02 15:04")))
+	return job, nil
 }
 
-// UnpinCluster unpins the item with given path to cluster.
-func UnpinCluster(b *hb.Bot, actor, path string) {
-	clusterPinUnpin(b, actor, path, "", false)
+// UnpinCluster unpins the item with given path to cluster. It returns the
+// job watching the unpin once it has been submitted.
+func UnpinCluster(actor string, from Sender, path string) (Job, error) {
+	e := newJournalEntry(OpUnpin, actor, from, path, "")
+	j, ctx := jobs.Start(e)
+	job, err := clusterPinUnpin(ctx, actor, e, j, false)
+	if err == nil {
+		if _, err := schedule.Remove(job.Cid); err != nil {
+			entryLogger(e).Error("failed to save the expiry schedule", "err", err)
+		}
+		if err := labels.Unpinned(job.Cid); err != nil {
+			entryLogger(e).Error("failed to save the labels", "err", err)
+		}
+	}
+	return job, err
 }
 
 // RecoverCluster tries to recover item with give path, if it's previous pin or
-// unpin operation was failed.
-func RecoverCluster(b *hb.Bot, actor, path string) {
-	ctx := context.Background()
-
+// unpin operation was failed. It returns the job watching the recovery.
+func RecoverCluster(actor string, from Sender, path string) (Job, error) {
 	botMsg(actor, fmt.Sprintf("Recovering pin with path %s", path))
 
+	e := newJournalEntry(OpRecover, actor, from, path, "")
+	j, ctx := jobs.Start(e)
+
+	cl := clients.Load()
+
 	// pick up a random shell
-	shell := shs[r.Intn(len(shs))]
+	shell := cl.randomShell()
 
 	c, err := resolveCid(path, shell)
 	if err != nil {
+		e.Result, e.Error = ResultFailed, err.Error()
+		logOp(actor, e)
+		jobs.Done(j)
 		botMsg(actor, fmt.Sprintf("could not determine cid to recover: %s", err))
-		return
+		return Job{}, err
 	}
+	e.Cid = c.String()
 
 	if c.String() != path {
 		botMsg(actor, fmt.Sprintf("%s resolved as %s", path, c))
 	}
 
-	gpi, err := lbClient.Recover(ctx, c, false)
+	gpi, err := cl.lbClient.Recover(ctx, c, false)
 	if err != nil {
+		if jobCanceled(ctx, actor, j, e) {
+			return Job{}, errJobCanceled
+		}
+		e.Result, e.Error = ResultFailed, err.Error()
+		logOp(actor, e)
+		jobs.Done(j)
 		botMsg(actor, fmt.Sprintf("failed to recover: %s", err))
-		return
+		return Job{}, err
 	}
+	e.Result = ResultSubmitted
+	e.setPeers(gpi)
+	logOp(actor, e)
 	botMsg(actor, fmt.Sprintf("Recover operation triggered for %s. You can later manually track the status with !status <cid>", c))
-	prettyClusterStatus(actor, gpi)
+	botMsg(actor, summarizeStatus(gpi))
+
+	job := jobs.Wait(j, *e, c, recoverTarget(gpi))
+	botMsg(actor, fmt.Sprintf("%s: watching recovery as job %d", c, j.ID))
+	return job, nil
+}
+
+// CancelJob cancels the job with the given id. With unpin, a cluster pin
+// that has already been submitted is undone as well.
+func CancelJob(actor string, from Sender, id int, unpin bool) {
+	j, err := jobs.Cancel(id)
+	if err != nil {
+		botMsg(actor, fmt.Sprintf("failed to cancel: %s", err))
+		return
+	}
+	botMsg(actor, fmt.Sprintf("canceling job %d", j.ID))
+
+	if !unpin {
+		return
+	}
+	if j.Op != OpPin || j.Cid == "" {
+		botMsg(actor, fmt.Sprintf("job %d has no cluster pin to undo", j.ID))
+		return
+	}
+	UnpinCluster(actor, from, j.Cid)
+}
+
+// recoverTarget guesses whether a recovered item is meant to end up pinned
+// or unpinned.
+func recoverTarget(gpi *api.GlobalPinInfo) api.TrackerStatus {
+	pinning := api.TrackerStatusPinned | api.TrackerStatusPinning |
+		api.TrackerStatusPinQueued | api.TrackerStatusPinError
+	for _, info := range gpi.PeerMap {
+		if info.Status.Match(pinning) {
+			return api.TrackerStatusPinned
+		}
+	}
+	return api.TrackerStatusUnpinned
+}
+
+// logOp writes e to the journal, complaining to actor if that fails.
+func logOp(actor string, e *JournalEntry) {
+	l := entryLogger(e)
+	switch e.Result {
+	case ResultFailed, ResultTimeout:
+		l.Warn(e.Op, "result", e.Result, "err", e.Error)
+	default:
+		l.Info(e.Op, "result", e.Result)
+	}
+
+	if err := journal.Append(e); err != nil {
+		l.Error("failed to write journal entry", "err", err)
+		botMsg(actor, fmt.Sprintf("failed to write log entry for last %s: %s", e.Op, err))
+	}
+	operationsTotal.Inc(e.Op, e.Result)
+	if err := outbox.Notify(e); err != nil {
+		l.Error("failed to queue webhooks", "err", err)
+		botMsg(actor, fmt.Sprintf("failed to queue webhooks for last %s: %s", e.Op, err))
+	}
+}
+
+// entryLogger returns a logger carrying the context of the operation
+// recorded in e.
+func entryLogger(e *JournalEntry) log.Logger {
+	target := e.Cid
+	if target == "" {
+		target = e.Path
+	}
+	return logger.New("actor", e.Nick, "channel", e.Channel, "cid", target, "job", e.Job, "entry", e.ID)
 }
 
 func resolveCid(path string, sh *shell.Shell) (cid.Cid, error) {
@@ -334,11 +611,16 @@ func resolveCid(path string, sh *shell.Shell) (cid.Cid, error) {
 	return cid.Decode(parts[2])
 }
 
-func waitForClusterOp(actor string, c cid.Cid, target api.TrackerStatus) {
-	botMsg(actor, fmt.Sprintf("%s: operation submitted. Waiting for status to reach %s", c, target))
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
-	defer cancel()
+// waitForClusterOp watches the cluster until the item of job j reaches its
+// target status, and reports and journals the outcome.
+func waitForClusterOp(ctx context.Context, j *Job) {
+	c, err := cid.Decode(j.Cid)
+	if err != nil {
+		botMsg(j.Actor, fmt.Sprintf("job %d: bad cid %q: %s", j.ID, j.Cid, err))
+		return
+	}
+	target := api.TrackerStatusFromString(j.Target)
+	e := j.Entry
 
 	fp := cluster.StatusFilterParams{
 		Cid:       c,
@@ -347,13 +629,26 @@ func waitForClusterOp(actor string, c cid.Cid, target api.TrackerStatus) {
 		CheckFreq: 5 * time.Second,
 	}
 
-	gpi, err := cluster.WaitFor(ctx, lbClient, fp)
+	gpi, err := cluster.WaitFor(ctx, clients.Load().lbClient, fp)
+	e.Time = time.Now().UTC()
 	if err != nil {
+		if ctx.Err() == context.Canceled {
+			e.Result = ResultCanceled
+			logOp(j.Actor, &e)
+			botMsg(j.Actor, fmt.Sprintf("%s: job %d: stopped watching %s", j.Nick, j.ID, c))
+			return
+		}
 		if ctx.Err() == context.DeadlineExceeded {
-			botMsg(actor, fmt.Sprintf("%s: still not '%s'. I won't keep watching, but you can run !status <cid> to check manually.", c, target))
+			e.Result = ResultTimeout
+			logOp(j.Actor, &e)
+			botMsg(j.Actor, fmt.Sprintf("%s: job %d: %s still not '%s'. I won't keep watching, but you can run !status <cid> to check manually.", j.Nick, j.ID, c, target))
+			versionFailed(j)
 			return
 		}
-		botMsg(actor, fmt.Sprintf("%s: an error happened: %s. You can attempt recovery with !recover <cid>.", c, err))
+		e.Result, e.Error = ResultFailed, err.Error()
+		logOp(j.Actor, &e)
+		botMsg(j.Actor, fmt.Sprintf("%s: job %d: %s: an error happened: %s. You can attempt recovery with !recover <cid>.", j.Nick, j.ID, c, err))
+		versionFailed(j)
 		return
 	}
 
@@ -364,11 +659,17 @@ func waitForClusterOp(actor string, c cid.Cid, target api.TrackerStatus) {
 		}
 	}
 
-	botMsg(actor, fmt.Sprintf("Reached %s in %d cluster peers: %s/ipfs/%s .", target, done, gateway, c))
+	if target == api.TrackerStatusPinned && j.Op == OpPin {
+		timeToPinnedSeconds.Observe(time.Since(j.Created).Seconds())
+	}
+
+	e.Result = target.String()
+	e.setPeers(gpi)
+	logOp(j.Actor, &e)
+	botMsg(j.Actor, fmt.Sprintf("%s: job %d: reached %s in %d cluster peers: %s/ipfs/%s .", j.Nick, j.ID, target, done, gateway, c))
 }
 
-func clusterPinUnpin(b *hb.Bot, actor, path, label string, pin bool) {
-	ctx := context.Background()
+func clusterPinUnpin(ctx context.Context, actor string, e *JournalEntry, j *Job, pin bool) (Job, error) {
 	verb := "pin"
 	if !pin {
 		verb = "unpin"
@@ -378,181 +679,372 @@ func clusterPinUnpin(b *hb.Bot, actor, path, label string, pin bool) {
 
 	var pinObj *api.Pin
 	var err error
+	var target api.TrackerStatus
+	lbClient := clients.Load().lbClient
 
 	switch pin {
 	case true:
-		pinObj, err = lbClient.PinPath(ctx, path, api.PinOptions{Name: label})
-		if err == nil {
-			go waitForClusterOp(actor, pinObj.Cid, api.TrackerStatusPinned)
+		var opts PinOpts
+		if e.Options != nil {
+			opts = *e.Options
 		}
-	case false:
-		pinObj, err = lbClient.UnpinPath(ctx, path)
+		var po api.PinOptions
+		po, err = opts.apiOptions(ctx, e)
 		if err == nil {
-			go waitForClusterOp(actor, pinObj.Cid, api.TrackerStatusUnpinned)
+			pinObj, err = lbClient.PinPath(ctx, e.Path, po)
 		}
+		target = api.TrackerStatusPinned
+	case false:
+		pinObj, err = lbClient.UnpinPath(ctx, e.Path)
+		target = api.TrackerStatusUnpinned
 	}
 
 	if err != nil {
+		if jobCanceled(ctx, actor, j, e) {
+			return Job{}, errJobCanceled
+		}
+		e.Result, e.Error = ResultFailed, err.Error()
+		logOp(actor, e)
+		jobs.Done(j)
 		botMsg(actor, fmt.Sprintf("failed to %s in cluster: %s", verb, err))
-		return
+		return Job{}, err
 	}
-}
 
-var shs []*shell.Shell
-var shsUrls []string
+	e.Cid = pinObj.Cid.String()
+	e.Result = ResultSubmitted
+	logOp(actor, e)
+	job := jobs.Wait(j, *e, pinObj.Cid, target)
+	with := ""
+	if e.Options != nil {
+		with = " with " + e.Options.String()
+	}
+	botMsg(actor, fmt.Sprintf("%s: operation submitted as job %d%s. Waiting for status to reach %s", pinObj.Cid, j.ID, with, target))
+	return job, nil
+}
 
-var lbClient cluster.Client
+var friendsExpiryCheck = time.Minute
 
-func loadHosts(file string) []string {
-	fi, err := os.Open(file)
-	if err != nil {
-		fmt.Printf("failed to open %s hosts file, defaulting to localhost:5001\n", file)
-		return []string{"/ip4/127.0.0.1/tcp/5001"}
+// expireFriends periodically drops friends whose grants have run out and
+// tells channel about it.
+func expireFriends(channel string) {
+	for range time.Tick(friendsExpiryCheck) {
+		expired, err := friends.Expire(time.Now())
+		for _, f := range expired {
+			botMsg(channel, fmt.Sprintf("%s is no longer %s: the grant has expired", f.Name, f.Role))
+		}
+		if err != nil {
+			botMsg(channel, fmt.Sprintf("failed to write friends file after expiring grants: %s", err))
+		}
 	}
+}
 
-	var hosts []string
-	scan := bufio.NewScanner(fi)
-	for scan.Scan() {
-		hosts = append(hosts, scan.Text())
-	}
-	return hosts
+// Clients holds the IPFS shells and the cluster client. It is replaced as a
+// whole when the configuration is reloaded.
+type Clients struct {
+	shs     []*shell.Shell
+	shsUrls []string
+	// shsPeers names the peer or host behind each shell, as configured.
+	shsPeers []string
+	lbClient cluster.Client
+}
+
+var clients atomic.Pointer[Clients]
+
+func (cl *Clients) randomShell() *shell.Shell {
+	return cl.shs[r.Intn(len(cl.shs))]
 }
 
-func ensurePinLogExists() error {
-	_, err := os.Stat(pinfile)
-	if os.IsNotExist(err) {
-		fi, err := os.Create(pinfile)
+// setupClients creates the IPFS shells and the load-balancing cluster
+// client for the peers and hosts in cfg.
+func setupClients(cfg *Config) (*Clients, error) {
+	ctx := context.Background()
+	cl := &Clients{}
+
+	var cfgs []*cluster.Config
+	for _, p := range cfg.Cluster.Peers {
+		maddr, err := ma.NewMultiaddr(p.Addr)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		fi.Close()
+		ccfg := &cluster.Config{
+			APIAddr:      maddr,
+			Username:     cfg.Cluster.Username,
+			Password:     cfg.Cluster.Password,
+			SSL:          p.SSL,
+			NoVerifyCert: p.NoVerifyCert,
+		}
+		if p.Username != "" {
+			ccfg.Username = p.Username
+			ccfg.Password = p.Password
+		}
+
+		cfgs = append(cfgs, ccfg)
+
+		client, err := cluster.NewDefaultClient(ccfg)
+		if err != nil {
+			return nil, err
+		}
+		cl.shs = append(cl.shs, client.IPFS(ctx))
+		cl.shsUrls = append(
+			cl.shsUrls,
+			fmt.Sprintf("http://127.0.0.1:%d", cluster.DefaultProxyPort),
+		)
+		cl.shsPeers = append(cl.shsPeers, p.Addr)
 	}
-	return nil
+
+	var err error
+	cl.lbClient, err = cluster.NewLBClient(&cluster.Failover{}, cfgs, cfg.Cluster.Retries)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Cluster.Peers) == 0 {
+		for _, h := range cfg.Hosts {
+			cl.shs = append(cl.shs, shell.NewShell(h))
+			cl.shsUrls = append(cl.shsUrls, "http://"+h)
+			cl.shsPeers = append(cl.shsPeers, h)
+		}
+	}
+	return cl, nil
+}
+
+// applyConfig sets the package-level settings from cfg.
+func applyConfig(cfg *Config) {
+	prefix = cfg.Prefix
+	gateway = cfg.Gateway
+	friendsFile = cfg.Files.Friends
+	journal.file = cfg.Files.Journal
+	legacyPinfile = cfg.Files.LegacyPins
+	jobs.file = cfg.Files.Jobs
+	outbox.file = cfg.Files.Outbox
+	schedule.file = cfg.Files.Schedule
+	labels.file = cfg.Files.Labels
+	jobTimeout = cfg.JobTimeout.Duration
+	ircServer = cfg.Server
+	ircBucket.Set(cfg.Flood.Burst, cfg.Flood.Interval.Duration)
 }
 
 func main() {
-	ctx := context.Background()
-	name := flag.String("name", "pinbot-test", "set pinbot's nickname")
-	server := flag.String("server", "irc.freenode.net:6667", "set server to connect to")
-	channel := flag.String("channel", "#pinbot-test", "set channel to join")
-	pre := flag.String("prefix", "!", "prefix of command messages")
-	gw := flag.String("gateway", "https://ipfs.io", "IPFS-to-HTTP gateway to use for success messages")
+	def := DefaultConfig()
+	cfgPath := flag.String("config", configFile, "path to the config file")
+	name := flag.String("name", def.Name, "set pinbot's nickname")
+	server := flag.String("server", def.Server, "set server to connect to")
+	channel := flag.String("channel", def.Channel, "set channel to join")
+	pre := flag.String("prefix", def.Prefix, "prefix of command messages")
+	gw := flag.String("gateway", def.Gateway, "IPFS-to-HTTP gateway to use for success messages")
 	username := flag.String("user", "", "Cluster API username")
 	pw := flag.String("pw", "", "Cluster API pw")
+	workers := flag.Int("workers", def.Workers, "number of commands to run at once")
+	queue := flag.Int("queue", def.Queue, "number of commands allowed to wait for a worker")
 
 	flag.Parse()
 
-	prefix = *pre
-	gateway = *gw
-	msgs = make(chan msgWrap, 500)
-	go messageQueueProcess()
+	if flag.Arg(0) == "check-config" {
+		file := *cfgPath
+		if flag.NArg() > 1 {
+			file = flag.Arg(1)
+		}
+		os.Exit(checkConfig(file))
+	}
 
-	err := ensurePinLogExists()
+	// flags that were given override the config file, also on reload
+	overrides := func(cfg *Config) {
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "name":
+				cfg.Name = *name
+			case "server":
+				cfg.Server = *server
+			case "channel":
+				cfg.Channel = *channel
+			case "prefix":
+				cfg.Prefix = *pre
+			case "gateway":
+				cfg.Gateway = *gw
+			case "user":
+				cfg.Cluster.Username = *username
+			case "pw":
+				cfg.Cluster.Password = *pw
+			case "workers":
+				cfg.Workers = *workers
+			case "queue":
+				cfg.Queue = *queue
+			}
+		})
+	}
+
+	cfg, err := LoadConfig(*cfgPath)
 	if err != nil {
 		panic(err)
 	}
+	overrides(cfg)
 
-	clusterpeers := loadHosts("clusterpeers")
-	var cfgs []*cluster.Config
-	for _, line := range clusterpeers {
-		spl := strings.Split(line, ",")
-		if len(spl) == 0 {
-			panic("bad host in cluster peers")
-		}
-		h := spl[0]
-		maddr, err := ma.NewMultiaddr(h)
-		if err != nil {
-			panic(err)
+	if errs := cfg.Validate(); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, "config:", err)
 		}
+		os.Exit(exitConfig)
+	}
+	if err := setupLogging(cfg.Log); err != nil {
+		fmt.Fprintln(os.Stderr, "config:", err)
+		os.Exit(exitConfig)
+	}
+	for _, err := range cfg.warnings {
+		logger.Warn("config", "err", err)
+	}
+	applyConfig(cfg)
 
-		cfg := &cluster.Config{
-			APIAddr:  maddr,
-			Username: *username,
-			Password: *pw,
-		}
+	msgs = make(chan msgWrap, 500)
+	go messageQueueProcess()
+	pool = NewWorkerPool(cfg.Workers, cfg.Queue)
 
-		cfgs = append(cfgs, cfg)
+	imported, err := journal.ImportLegacy(legacyPinfile)
+	if err != nil {
+		panic(err)
+	}
+	if imported > 0 {
+		logger.Info("imported legacy pins", "count", imported, "file", legacyPinfile)
+	}
 
-		if len(spl) > 1 && spl[1] == "ssl" {
-			cfg.SSL = true
-		}
+	cl, err := setupClients(cfg)
+	if err != nil {
+		panic(err)
+	}
+	clients.Store(cl)
+
+	// every transport has to be known before resumed jobs and the outbox
+	// queue messages for their addresses
+	RegisterTransport(ircTransport{})
+	var mx *Matrix
+	if cfg.Matrix != nil {
+		mx = NewMatrix(*cfg.Matrix)
+		RegisterTransport(mx)
+	}
+	var httpAPI *API
+	if cfg.API != nil {
+		httpAPI = NewAPI(*cfg.API, cfg.Channel)
+		RegisterTransport(httpAPI)
+	}
 
-		if len(spl) > 1 && spl[1] == "sslnoverify" {
-			cfg.SSL = true
-			cfg.NoVerifyCert = true
-		}
+	outbox.SetTargets(cfg.Webhooks)
+	if err := outbox.Load(); err != nil {
+		panic(err)
+	}
+	go outbox.Run()
 
-		client, err := cluster.NewDefaultClient(cfg)
-		if err != nil {
-			panic(err)
-		}
-		shs = append(shs, client.IPFS(ctx))
-		shsUrls = append(
-			shsUrls,
-			fmt.Sprintf("http://127.0.0.1:%d", cluster.DefaultProxyPort),
-		)
+	if err := jobs.Load(); err != nil {
+		panic(err)
+	}
+	if n := jobs.Resume(); n > 0 {
+		logger.Info("resumed unfinished jobs", "count", n)
 	}
 
-	lbClient, err = cluster.NewLBClient(&cluster.Failover{}, cfgs, retries)
-	if err != nil {
+	if err := schedule.Load(); err != nil {
 		panic(err)
 	}
+	go schedule.Run()
 
-	if len(clusterpeers) == 0 {
-		for _, h := range loadHosts("hosts") {
-			shs = append(shs, shell.NewShell(h))
-			shsUrls = append(shsUrls, "http://"+h)
-		}
+	if err := labels.Load(); err != nil {
+		panic(err)
+	}
+
+	rs, err := LoadRoles(cfg)
+	if err != nil {
+		panic(err)
 	}
+	setRoles(rs)
 
 	if err := friends.Load(); err != nil {
 		if os.IsNotExist(err) {
-			friends = DefaultFriendsList
+			friends.Set(maps.Clone(DefaultFriends))
 		} else {
 			panic(err)
 		}
 	}
-	fmt.Println("loaded", len(friends.friends), "friends")
+	logger.Info("loaded friends", "count", len(friends.Names()))
+	go expireFriends(cfg.Channel)
+
+	reloader = &Reloader{file: *cfgPath, overrides: overrides, cfg: cfg}
+	go reloadOnHangup(cfg.Channel)
+	go exitOnSignal(cfg.Channel)
+
+	if mx != nil {
+		go mx.Run(context.Background())
+	}
+
+	if httpAPI != nil {
+		ln, err := net.Listen("tcp", cfg.API.Listen)
+		if err != nil {
+			panic(err)
+		}
+		go func() {
+			panic(httpAPI.Serve(ln))
+		}()
+		logger.Info("serving the HTTP API", "addr", ln.Addr())
+	}
+
+	if cfg.Reports != nil {
+		ln, err := net.Listen("tcp", cfg.Reports.Listen)
+		if err != nil {
+			panic(err)
+		}
+		reports = NewReportServer(*cfg.Reports)
+		go func() {
+			panic(reports.Serve(ln))
+		}()
+		logger.Info("serving reports", "addr", ln.Addr())
+	}
+
+	if cfg.Metrics != "" {
+		ln, err := net.Listen("tcp", cfg.Metrics)
+		if err != nil {
+			panic(err)
+		}
+		go func() {
+			panic(ServeMetrics(ln))
+		}()
+		go watchPeerErrors()
+		logger.Info("serving metrics", "addr", ln.Addr())
+	}
 
-	bot, err = newBot(*server, *name)
+	bot, err = newBot(cfg.Server, cfg.Name)
 	if err != nil {
 		panic(err)
 	}
 
-	connectToFreenodeIpfs(bot, *channel)
-	fmt.Println("Connection lost! attempting to reconnect!")
+	connectToFreenodeIpfs(bot, cfg.Channel)
 	bot.Close()
+	connectionLost()
 
 	recontime := time.Second
 	for {
 		// Dont try to reconnect this time
-		bot, err = newBot(*server, *name)
+		bot, err = newBot(cfg.Server, cfg.Name)
 		if err != nil {
-			fmt.Println("ERROR CONNECTING: ", err)
+			logger.Error("failed to connect", "server", cfg.Server, "err", err)
 			time.Sleep(recontime)
 			recontime += time.Second
 			continue
 		}
 		recontime = time.Second
 
-		connectToFreenodeIpfs(bot, *channel)
-		fmt.Println("Connection lost! attempting to reconnect!")
+		connectToFreenodeIpfs(bot, cfg.Channel)
 		bot.Close()
+		connectionLost()
 	}
 }
 
 func newBot(server, name string) (bot *hb.Bot, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Println(r)
+			logger.Error("bot creation panicked", "panic", r)
 			err = errors.New("bot creation panicked")
 		}
 	}()
 	bot, err = hb.NewBot(server, name)
 	if err == nil {
-		logHandler := log.LvlFilterHandler(log.LvlDebug, log.StdoutHandler)
-		bot.SetHandler(logHandler)
+		bot.SetHandler(ircLog)
 		bot.PingTimeout = time.Hour * 24 * 7
 	}
 	return
@@ -562,22 +1054,15 @@ func connectToFreenodeIpfs(con *hb.Bot, channel string) {
 	// Run() panics badly sometimes
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Println(r)
+			logger.Error("IRC connection panicked", "panic", r)
 		}
 	}()
-	con.AddTrigger(pinTrigger)
-	con.AddTrigger(unpinTrigger)
-	con.AddTrigger(pinClusterTrigger)
-	con.AddTrigger(unpinClusterTrigger)
-	con.AddTrigger(statusClusterTrigger)
-	con.AddTrigger(statusOngoingTrigger)
-	con.AddTrigger(recoverClusterTrigger)
-	con.AddTrigger(listTrigger)
-	con.AddTrigger(befriendTrigger)
-	con.AddTrigger(shunTrigger)
-	con.AddTrigger(OmNomNom)
+	con.AddTrigger(joinedTrigger(channel))
+	con.AddTrigger(accountTrigger)
+	con.AddTrigger(commandTrigger)
 	con.AddTrigger(EatEverything)
 	con.Channels = []string{channel}
+	defer connected.Store(false)
 	con.Run()
 
 	// clears anything remaining in incoming
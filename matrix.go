@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	matrixTransport = "matrix"
+	// matrixSyncTimeout is how long the homeserver may hold a sync
+	// request open waiting for new events.
+	matrixSyncTimeout = 30 * time.Second
+	// matrixMaxLine keeps messages well under the 64 KiB limit on events.
+	matrixMaxLine = 32 << 10
+	// matrixBurst and matrixInterval match the message rate limit Synapse
+	// applies by default.
+	matrixBurst    = 10
+	matrixInterval = 5 * time.Second
+)
+
+// Matrix serves commands in Matrix rooms through the client-server API.
+type Matrix struct {
+	cfg    MatrixConfig
+	client *http.Client
+	txn    atomic.Int64
+	bucket *TokenBucket
+
+	mu sync.Mutex
+	// rooms holds the IDs of the joined rooms that commands are taken
+	// from.
+	rooms map[string]bool
+}
+
+func NewMatrix(cfg MatrixConfig) *Matrix {
+	return &Matrix{
+		cfg:    cfg,
+		client: &http.Client{Timeout: matrixSyncTimeout + 30*time.Second},
+		bucket: NewTokenBucket(matrixBurst, matrixInterval),
+		rooms:  make(map[string]bool),
+	}
+}
+
+// validMatrixID reports whether id looks like a Matrix user ID.
+func validMatrixID(id string) bool {
+	return strings.HasPrefix(id, "@") && strings.Contains(id, ":") && !strings.ContainsAny(id, " \t")
+}
+
+func (m *Matrix) Name() string {
+	return matrixTransport
+}
+
+// Network makes Matrix a Networker.
+func (m *Matrix) Network() string {
+	return hostOf(m.cfg.Homeserver)
+}
+
+// MaxLine makes Matrix a Limiter.
+func (m *Matrix) MaxLine(to string) int {
+	return matrixMaxLine
+}
+
+func (m *Matrix) Bucket() *TokenBucket {
+	return m.bucket
+}
+
+func (m *Matrix) Msg(to, msg string) {
+	m.send(to, "m.text", msg)
+}
+
+func (m *Matrix) Notice(to, msg string) {
+	m.send(to, "m.notice", msg)
+}
+
+func (m *Matrix) send(room, msgtype, body string) {
+	path := fmt.Sprintf("/rooms/%s/send/m.room.message/pinbot-%d-%d",
+		url.PathEscape(room), time.Now().UnixNano(), m.txn.Add(1))
+	content := map[string]string{"msgtype": msgtype, "body": body}
+
+	// the transaction ID makes retries safe; waiting out a rate limit
+	// only holds up the other Matrix messages, as every transport has a
+	// sender of its own
+	for tries := 0; ; tries++ {
+		err := m.do(context.Background(), "PUT", path, nil, content, nil)
+		if merr, ok := err.(*matrixError); ok && merr.RetryAfter > 0 && tries < 3 {
+			time.Sleep(merr.RetryAfter)
+			continue
+		}
+		if err != nil {
+			logger.Error("matrix: failed to send", "room", room, "err", err)
+		}
+		return
+	}
+}
+
+// matrixError is an error response from the homeserver.
+type matrixError struct {
+	Status     int
+	Code       string `json:"errcode"`
+	Message    string `json:"error"`
+	RetryAfter time.Duration
+}
+
+func (e *matrixError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("HTTP %d", e.Status)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// do calls the client-server API endpoint path with the JSON body in, and
+// decodes the response into out when it is not nil.
+func (m *Matrix) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	u := strings.TrimSuffix(m.cfg.Homeserver, "/") + "/_matrix/client/v3" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.cfg.AccessToken)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		merr := &matrixError{Status: resp.StatusCode}
+		var retry struct {
+			RetryAfterMs int64 `json:"retry_after_ms"`
+		}
+		buf, _ := io.ReadAll(resp.Body)
+		json.Unmarshal(buf, merr)
+		json.Unmarshal(buf, &retry)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			merr.RetryAfter = max(time.Duration(retry.RetryAfterMs)*time.Millisecond, time.Second)
+		}
+		return merr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// join joins the configured rooms and remembers their IDs.
+func (m *Matrix) join(ctx context.Context) error {
+	for _, room := range m.cfg.Rooms {
+		var resp struct {
+			RoomID string `json:"room_id"`
+		}
+		if err := m.do(ctx, "POST", "/join/"+url.PathEscape(room), nil, struct{}{}, &resp); err != nil {
+			return fmt.Errorf("joining %s: %s", room, err)
+		}
+		m.mu.Lock()
+		m.rooms[resp.RoomID] = true
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+type matrixSync struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []matrixEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+type matrixEvent struct {
+	Type    string `json:"type"`
+	Sender  string `json:"sender"`
+	Content struct {
+		MsgType string `json:"msgtype"`
+		Body    string `json:"body"`
+	} `json:"content"`
+}
+
+// syncFilter keeps sync responses down to room messages.
+const syncFilter = `{"presence":{"not_types":["*"]},"account_data":{"not_types":["*"]},` +
+	`"room":{"state":{"lazy_load_members":true},"ephemeral":{"not_types":["*"]},"account_data":{"not_types":["*"]},` +
+	`"timeline":{"types":["m.room.message"]}}}`
+
+func (m *Matrix) sync(ctx context.Context, since string, timeout time.Duration) (*matrixSync, error) {
+	q := url.Values{}
+	q.Set("filter", syncFilter)
+	q.Set("timeout", fmt.Sprint(timeout.Milliseconds()))
+	if since != "" {
+		q.Set("since", since)
+	}
+
+	var resp matrixSync
+	if err := m.do(ctx, "GET", "/sync", q, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Run serves commands until ctx is done, reconnecting after errors.
+func (m *Matrix) Run(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := m.run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Warn("matrix: disconnected, retrying", "err", err, "backoff", backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+func (m *Matrix) run(ctx context.Context) error {
+	if err := m.join(ctx); err != nil {
+		return err
+	}
+
+	// skip whatever was said before we got here
+	s, err := m.sync(ctx, "", 0)
+	if err != nil {
+		return err
+	}
+	logger.Info("matrix: connected", "user", m.cfg.UserID)
+
+	since := s.NextBatch
+	for {
+		s, err := m.sync(ctx, since, matrixSyncTimeout)
+		if err != nil {
+			return err
+		}
+		since = s.NextBatch
+
+		for room, joined := range s.Rooms.Join {
+			m.mu.Lock()
+			ours := m.rooms[room]
+			m.mu.Unlock()
+			if !ours {
+				continue
+			}
+			for _, ev := range joined.Timeline.Events {
+				if ev.Type != "m.room.message" || ev.Content.MsgType != "m.text" || ev.Sender == m.cfg.UserID {
+					continue
+				}
+				// handlers may block, as they do on IRC
+				go Handle(matrixCommand{m: m, room: room, sender: ev.Sender, body: ev.Content.Body})
+			}
+		}
+	}
+}
+
+// matrixCommand is a Command received in a Matrix room.
+type matrixCommand struct {
+	m      *Matrix
+	room   string
+	sender string
+	body   string
+}
+
+func (c matrixCommand) Transport() Transport {
+	return c.m
+}
+
+func (c matrixCommand) Sender() Sender {
+	return Sender{Nick: c.sender, MatrixID: c.sender}
+}
+
+func (c matrixCommand) Channel() string {
+	return c.room
+}
+
+// Private is the room the command came from, as Matrix has no cheap way to
+// reach a user directly.
+func (c matrixCommand) Private() string {
+	return c.room
+}
+
+func (c matrixCommand) Text() string {
+	return c.body
+}
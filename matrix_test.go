@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testRoomAlias = "#pinbot:example.org"
+	testRoomID    = "!room:example.org"
+	testBotID     = "@pinbot:example.org"
+	testToken     = "secret"
+)
+
+// fakeHomeserver stands in for a Matrix homeserver. Each sync after the
+// first returns the next batch of timeline events in syncs, then blocks.
+type fakeHomeserver struct {
+	t *testing.T
+
+	mu     sync.Mutex
+	joined []string
+	sinces []string
+	syncs  [][]matrixEvent
+	// throttle is how many sends are answered with 429 before one
+	// goes through.
+	throttle int
+	puts     []string
+	sent     []map[string]string
+}
+
+func newFakeHomeserver(t *testing.T) (*fakeHomeserver, *httptest.Server) {
+	hs := &fakeHomeserver{t: t}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /_matrix/client/v3/join/{room}", hs.join)
+	mux.HandleFunc("GET /_matrix/client/v3/sync", hs.sync)
+	mux.HandleFunc("PUT /_matrix/client/v3/rooms/{room}/send/m.room.message/{txn}", hs.send)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"errcode":"M_UNKNOWN_TOKEN","error":"bad token"}`)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return hs, srv
+}
+
+func (hs *fakeHomeserver) join(w http.ResponseWriter, r *http.Request) {
+	hs.mu.Lock()
+	hs.joined = append(hs.joined, r.PathValue("room"))
+	hs.mu.Unlock()
+	fmt.Fprintf(w, `{"room_id":%q}`, testRoomID)
+}
+
+func (hs *fakeHomeserver) sync(w http.ResponseWriter, r *http.Request) {
+	since := r.URL.Query().Get("since")
+	hs.mu.Lock()
+	hs.sinces = append(hs.sinces, since)
+	n := len(hs.sinces)
+	var events []matrixEvent
+	switch {
+	case since == "":
+		// what was said before pinbot got here
+		events = []matrixEvent{testEvent("@alice:example.org", "!ping old")}
+		if r.URL.Query().Get("timeout") != "0" {
+			hs.t.Errorf("first sync has timeout %s, want 0", r.URL.Query().Get("timeout"))
+		}
+	case n-2 < len(hs.syncs):
+		events = hs.syncs[n-2]
+	default:
+		hs.mu.Unlock()
+		<-r.Context().Done()
+		return
+	}
+	hs.mu.Unlock()
+
+	var resp matrixSync
+	resp.NextBatch = fmt.Sprintf("s%d", n)
+	resp.Rooms.Join = map[string]struct {
+		Timeline struct {
+			Events []matrixEvent `json:"events"`
+		} `json:"timeline"`
+	}{}
+	room := resp.Rooms.Join[testRoomID]
+	room.Timeline.Events = events
+	resp.Rooms.Join[testRoomID] = room
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (hs *fakeHomeserver) send(w http.ResponseWriter, r *http.Request) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.puts = append(hs.puts, r.PathValue("txn"))
+	if hs.throttle > 0 {
+		hs.throttle--
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"errcode":"M_LIMIT_EXCEEDED","error":"slow down","retry_after_ms":10}`)
+		return
+	}
+	var content map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
+		hs.t.Errorf("send: %s", err)
+	}
+	content["room"] = r.PathValue("room")
+	hs.sent = append(hs.sent, content)
+	fmt.Fprint(w, `{"event_id":"$event"}`)
+}
+
+func testEvent(sender, body string) matrixEvent {
+	ev := matrixEvent{Type: "m.room.message", Sender: sender}
+	ev.Content.MsgType = "m.text"
+	ev.Content.Body = body
+	return ev
+}
+
+func newTestMatrix(url string) *Matrix {
+	return NewMatrix(MatrixConfig{
+		Homeserver:  url,
+		UserID:      testBotID,
+		AccessToken: testToken,
+		Rooms:       []string{testRoomAlias},
+	})
+}
+
+func TestMatrixSync(t *testing.T) {
+	hs, srv := newFakeHomeserver(t)
+	hs.syncs = [][]matrixEvent{
+		{testEvent("@alice:example.org", "!ping one")},
+		{
+			testEvent(testBotID, "!ping from myself"),
+			testEvent("@bob:example.org", "!ping two"),
+		},
+	}
+
+	// a command of our own records what the sync loop hands over
+	got := make(chan Command, 10)
+	handlers["ping"] = func(c Command, args []string) { got <- c }
+	oldPrefix, oldRoles := prefix, currentRoles()
+	prefix = "!"
+	setRoles(Roles{EveryoneRole: {allCommands}})
+	t.Cleanup(func() {
+		delete(handlers, "ping")
+		prefix = oldPrefix
+		setRoles(oldRoles)
+	})
+
+	m := newTestMatrix(srv.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	want := []struct{ sender, text string }{
+		{"@alice:example.org", "!ping one"},
+		{"@bob:example.org", "!ping two"},
+	}
+	for _, w := range want {
+		select {
+		case c := <-got:
+			if c.Sender().MatrixID != w.sender || c.Text() != w.text || c.Channel() != testRoomID {
+				t.Errorf("got %q from %s in %s, want %q from %s in %s",
+					c.Text(), c.Sender().MatrixID, c.Channel(), w.text, w.sender, testRoomID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", w.text)
+		}
+	}
+	select {
+	case c := <-got:
+		t.Errorf("unexpected command %q from %s", c.Text(), c.Sender().MatrixID)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if len(hs.joined) != 1 || hs.joined[0] != testRoomAlias {
+		t.Errorf("joined %v, want [%s]", hs.joined, testRoomAlias)
+	}
+	wantSinces := []string{"", "s1", "s2", "s3"}
+	if len(hs.sinces) != len(wantSinces) {
+		t.Fatalf("synced with since %q, want %q", hs.sinces, wantSinces)
+	}
+	for i := range wantSinces {
+		if hs.sinces[i] != wantSinces[i] {
+			t.Fatalf("synced with since %q, want %q", hs.sinces, wantSinces)
+		}
+	}
+}
+
+func TestMatrixSend(t *testing.T) {
+	hs, srv := newFakeHomeserver(t)
+	hs.throttle = 1
+	m := newTestMatrix(srv.URL)
+
+	m.Msg(testRoomID, "hello")
+	m.Notice(testRoomID, "psst")
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if len(hs.puts) != 3 {
+		t.Fatalf("got %d sends, want 3 (one of them throttled)", len(hs.puts))
+	}
+	if hs.puts[0] != hs.puts[1] {
+		t.Errorf("retry used transaction %s, want %s", hs.puts[1], hs.puts[0])
+	}
+	if hs.puts[1] == hs.puts[2] {
+		t.Errorf("two messages share transaction %s", hs.puts[1])
+	}
+	want := []map[string]string{
+		{"room": testRoomID, "msgtype": "m.text", "body": "hello"},
+		{"room": testRoomID, "msgtype": "m.notice", "body": "psst"},
+	}
+	if len(hs.sent) != len(want) {
+		t.Fatalf("sent %v, want %v", hs.sent, want)
+	}
+	for i := range want {
+		for k, v := range want[i] {
+			if hs.sent[i][k] != v {
+				t.Errorf("message %d: %s = %q, want %q", i, k, hs.sent[i][k], v)
+			}
+		}
+	}
+}
+
+func TestMatrixErrors(t *testing.T) {
+	_, srv := newFakeHomeserver(t)
+	m := newTestMatrix(srv.URL)
+	m.cfg.AccessToken = "wrong"
+
+	err := m.join(context.Background())
+	if err == nil {
+		t.Fatal("join with a bad token succeeded")
+	}
+	if want := "joining " + testRoomAlias + ": M_UNKNOWN_TOKEN: bad token"; err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+}
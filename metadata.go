@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// Metadata keys pinbot sets on cluster pins. Users may not set keys with
+// the pinbot. prefix themselves.
+const (
+	metaPrefix  = "pinbot."
+	metaNick    = metaPrefix + "nick"
+	metaAccount = metaPrefix + "account"
+	metaChannel = metaPrefix + "channel"
+	metaNetwork = metaPrefix + "network"
+	metaTime    = metaPrefix + "time"
+	metaVersion = metaPrefix + "version"
+)
+
+// version can be set when building with -ldflags "-X main.version=v1.2.3".
+// Otherwise it comes from the build info.
+var version string
+
+// botVersion returns the version of pinbot that is running.
+func botVersion() string {
+	if version != "" {
+		return version
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	v := bi.Main.Version
+	for _, s := range bi.Settings {
+		if s.Key == "vcs.revision" && len(s.Value) >= 12 {
+			v += "+" + s.Value[:12]
+		}
+	}
+	return v
+}
+
+// metaKey is what keys of user metadata look like.
+var metaKey = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// parseMeta returns the key and value of a key=value argument, or false if
+// arg is not one.
+func parseMeta(arg string) (key, value string, ok bool) {
+	key, value, ok = strings.Cut(arg, "=")
+	if !ok || value == "" || !metaKey.MatchString(key) {
+		return "", "", false
+	}
+	return key, value, true
+}
+
+// formatMeta lists user metadata as key=value, sorted by key.
+func formatMeta(meta map[string]string) string {
+	parts := make([]string, 0, len(meta))
+	for _, k := range slices.Sorted(maps.Keys(meta)) {
+		parts = append(parts, k+"="+meta[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Networker is implemented by transports that know the name of the network
+// they are connected to.
+type Networker interface {
+	Network() string
+}
+
+// networkOf returns the network that addr is on.
+func networkOf(addr string) string {
+	t, _, err := resolve(addr)
+	if err != nil {
+		return ""
+	}
+	if n, ok := t.(Networker); ok {
+		return n.Network()
+	}
+	return t.Name()
+}
+
+// pinMetadata returns the metadata a cluster pin of e carries: the user's
+// own, and who asked for it where and when.
+func pinMetadata(e *JournalEntry) map[string]string {
+	meta := make(map[string]string)
+	if e.Options != nil {
+		for k, v := range e.Options.Metadata {
+			meta[k] = v
+		}
+	}
+	set := func(k, v string) {
+		if v != "" {
+			meta[k] = v
+		}
+	}
+	set(metaNick, e.Nick)
+	set(metaAccount, e.Account)
+	set(metaChannel, e.Channel)
+	set(metaNetwork, networkOf(e.Channel))
+	set(metaTime, e.Time.UTC().Format(time.RFC3339))
+	set(metaVersion, botVersion())
+	return meta
+}
+
+// requester describes who asked for the cluster pin of c, from the metadata
+// in the cluster or else from the journal. It returns "" if neither knows.
+func requester(ctx context.Context, c cid.Cid) string {
+	pin, err := clients.Load().lbClient.Allocation(ctx, c)
+	if err == nil && pin.Metadata[metaNick] != "" {
+		return describeRequester(pin.Metadata)
+	}
+	return journalRequester(c.String())
+}
+
+// journalRequester describes who asked for the latest pin of c in the
+// journal. Pins imported from pins.log do not say who asked for them, so
+// for those it gives the label or path they were pinned under instead.
+func journalRequester(c string) string {
+	results, err := SearchPins(PinQuery{Cid: c, Ops: []string{OpPin}})
+	if err != nil {
+		return ""
+	}
+	for _, e := range results {
+		if e.Nick != "" {
+			return describeRequester(map[string]string{
+				metaNick:    e.Nick,
+				metaAccount: e.Account,
+				metaChannel: e.Channel,
+				metaTime:    e.Time.UTC().Format(time.RFC3339),
+			})
+		}
+	}
+	for _, e := range results {
+		if !e.Imported {
+			continue
+		}
+		if e.Label != "" {
+			return "requested by unknown (pre-journal), labeled " + e.Label
+		}
+		return "requested by unknown (pre-journal), pinned as " + e.Path
+	}
+	return ""
+}
+
+// describeRequester renders pin metadata as "requested by nick (account)
+// in #channel on network, 2006-01-02".
+func describeRequester(meta map[string]string) string {
+	out := "requested by " + meta[metaNick]
+	if a := meta[metaAccount]; a != "" && a != meta[metaNick] {
+		out += fmt.Sprintf(" (%s)", a)
+	}
+	if ch := meta[metaChannel]; ch != "" {
+		out += " in " + ch
+	}
+	if n := meta[metaNetwork]; n != "" {
+		out += " on " + n
+	}
+	if t, err := time.Parse(time.RFC3339, meta[metaTime]); err == nil {
+		out += ", " + t.Format(time.DateOnly)
+	}
+	return out
+}
+
+// hostOf returns the host of URL u, or u itself if it has none.
+func hostOf(u string) string {
+	if p, err := url.Parse(u); err == nil && p.Host != "" {
+		return p.Host
+	}
+	return u
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJournalRequester(t *testing.T) {
+	j := withJournal(t)
+	at := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	records := []JournalEntry{
+		{ID: "a", Op: OpPin, Path: "one", Cid: "one", Label: "my site", Imported: true},
+		{ID: "b", Op: OpPin, Path: "/ipfs/two", Cid: "two", Imported: true},
+		{ID: "c", Op: OpPin, Path: "three", Cid: "three", Label: "docs", Imported: true},
+		{ID: "d", Op: OpPin, Nick: "alice", Account: "robert", Channel: "#ipfs", Cid: "three", Result: "pinned"},
+		{ID: "e", Op: OpUnpin, Nick: "bob", Cid: "four", Result: "unpinned"},
+		{ID: "f", Op: OpPin, Nick: "carol", Cid: "five", Result: ResultFailed},
+	}
+	for i := range records {
+		records[i].Time = at
+		if err := j.Append(&records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		cid, want string
+	}{
+		{"one", "requested by unknown (pre-journal), labeled my site"},
+		{"two", "requested by unknown (pre-journal), pinned as /ipfs/two"},
+		// pinned again since it was imported
+		{"three", "requested by alice (robert) in #ipfs, 2026-10-01"},
+		{"four", ""},
+		// still who asked, even though the pin then failed
+		{"five", "requested by carol, 2026-10-01"},
+		{"six", ""},
+	}
+	for _, tt := range tests {
+		if got := journalRequester(tt.cid); got != tt.want {
+			t.Errorf("journalRequester(%s) = %q, want %q", tt.cid, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// The metrics are exposed in the Prometheus text format. There are few
+// enough of them that they are kept by hand rather than with the client
+// library.
+
+// collector writes one metric family.
+type collector interface {
+	collect(w io.Writer)
+}
+
+var collectors []collector
+
+func register[C collector](c C) C {
+	collectors = append(collectors, c)
+	return c
+}
+
+var (
+	// commandsTotal counts whether commands got to run at all, and
+	// commandOutcomesTotal how the ones that ran went. The results of the
+	// cluster operations they start are in operationsTotal.
+	commandsTotal = register(newCounterVec("pinbot_commands_total",
+		"Commands received, by command and dispatch (accepted, denied or busy).", "command", "dispatch"))
+	commandOutcomesTotal = register(newCounterVec("pinbot_command_outcomes_total",
+		"Accepted commands that finished, by command and outcome (ok, usage, refused or failed).", "command", "outcome"))
+	operationsTotal = register(newCounterVec("pinbot_operations_total",
+		"Journaled pin, unpin and recover results, by operation and result.", "op", "result"))
+	tryPinSeconds = register(newHistogramVec("pinbot_trypin_duration_seconds",
+		"Time taken by legacy pins on each cluster peer or IPFS host.", expBuckets(0.5, 2, 12), "peer"))
+	timeToPinnedSeconds = register(newHistogramVec("pinbot_time_to_pinned_seconds",
+		"Time from a cluster pin request until it was pinned everywhere.", expBuckets(1, 2, 14)))
+	reconnectsTotal = register(newCounterVec("pinbot_irc_reconnects_total",
+		"Times the IRC connection was lost and had to be reestablished."))
+	peerErrors = register(newGaugeVec("pinbot_cluster_peer_errors",
+		"Items in error state on each cluster peer, as of the last StatusAll.", "peer"))
+
+	_ = register(gaugeFunc{"pinbot_message_queue_depth", "Lines waiting to be sent.", func() float64 {
+		return float64(len(msgs) + outq.Depth())
+	}})
+	_ = register(gaugeFunc{"pinbot_jobs_in_flight", "Jobs still watching the cluster.", func() float64 {
+		return float64(len(jobs.List()))
+	}})
+	_ = register(gaugeFunc{"pinbot_irc_connected", "Whether the bot is connected to IRC.", func() float64 {
+		if connected.Load() {
+			return 1
+		}
+		return 0
+	}})
+)
+
+// peerErrorsInterval is how often peerErrors is refreshed.
+var peerErrorsInterval = time.Minute
+
+// ServeMetrics serves /metrics on ln until it fails.
+func ServeMetrics(ln net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, c := range collectors {
+			c.collect(w)
+		}
+	})
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return srv.Serve(ln)
+}
+
+// watchPeerErrors keeps peerErrors up to date.
+func watchPeerErrors() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), peerErrorsInterval)
+		sts, err := clients.Load().lbClient.StatusAll(ctx, api.TrackerStatusError, false)
+		cancel()
+		if err != nil {
+			logger.Warn("metrics: failed to get cluster status", "err", err)
+		} else {
+			countPeerErrors(sts)
+		}
+		time.Sleep(peerErrorsInterval)
+	}
+}
+
+// countPeerErrors sets peerErrors from the statuses in sts.
+func countPeerErrors(sts []*api.GlobalPinInfo) {
+	counts := make(map[string]float64)
+	for _, st := range sts {
+		for _, info := range st.PeerMap {
+			if info.Status == api.TrackerStatusError {
+				counts[info.PeerName]++
+			}
+		}
+	}
+	peerErrors.Reset()
+	for peer, n := range counts {
+		peerErrors.Set(n, peer)
+	}
+}
+
+// series holds the values of a metric family by label values.
+type series[V any] struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]V
+	keys   map[string][]string
+}
+
+func newSeries[V any](name, help string, labels []string) series[V] {
+	return series[V]{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]V),
+		keys:   make(map[string][]string),
+	}
+}
+
+// get returns the value for lvs, creating it with init if needed. It must
+// be called with mu held.
+func (s *series[V]) get(lvs []string, init func() V) V {
+	if len(lvs) != len(s.labels) {
+		panic(fmt.Sprintf("%s: got %d label values, want %d", s.name, len(lvs), len(s.labels)))
+	}
+	key := strings.Join(lvs, "\xff")
+	v, ok := s.values[key]
+	if !ok {
+		v = init()
+		s.values[key] = v
+		s.keys[key] = slices.Clone(lvs)
+	}
+	return v
+}
+
+// each calls fn for every series in a stable order, with mu held.
+func (s *series[V]) each(fn func(labels string, v V)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range slices.Sorted(maps.Keys(s.values)) {
+		fn(formatLabels(s.labels, s.keys[key]), s.values[key])
+	}
+}
+
+func (s *series[V]) header(w io.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, typ)
+}
+
+type counterVec struct {
+	series[*float64]
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{newSeries[*float64](name, help, labels)}
+}
+
+// Inc adds one to the counter with label values lvs.
+func (c *counterVec) Inc(lvs ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.get(lvs, func() *float64 { return new(float64) })++
+}
+
+func (c *counterVec) collect(w io.Writer) {
+	c.header(w, "counter")
+	if len(c.labels) == 0 {
+		// report unlabeled counters even before they count anything
+		c.mu.Lock()
+		c.get(nil, func() *float64 { return new(float64) })
+		c.mu.Unlock()
+	}
+	c.each(func(labels string, v *float64) {
+		fmt.Fprintf(w, "%s%s %g\n", c.name, labels, *v)
+	})
+}
+
+type gaugeVec struct {
+	series[*float64]
+}
+
+func newGaugeVec(name, help string, labels ...string) *gaugeVec {
+	return &gaugeVec{newSeries[*float64](name, help, labels)}
+}
+
+// Set sets the gauge with label values lvs to v.
+func (g *gaugeVec) Set(v float64, lvs ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	*g.get(lvs, func() *float64 { return new(float64) }) = v
+}
+
+// Reset drops every series.
+func (g *gaugeVec) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	clear(g.values)
+	clear(g.keys)
+}
+
+func (g *gaugeVec) collect(w io.Writer) {
+	g.header(w, "gauge")
+	g.each(func(labels string, v *float64) {
+		fmt.Fprintf(w, "%s%s %g\n", g.name, labels, *v)
+	})
+}
+
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func (g gaugeFunc) collect(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", g.name, g.help, g.name, g.name, g.fn())
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type histogramVec struct {
+	series[*histogram]
+	buckets []float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{newSeries[*histogram](name, help, labels), buckets}
+}
+
+// expBuckets returns n bucket bounds, the first being start and each next
+// one factor times the previous.
+func expBuckets(start, factor float64, n int) []float64 {
+	b := make([]float64, n)
+	for i := range b {
+		b[i] = start
+		start *= factor
+	}
+	return b
+}
+
+// Observe records v in the histogram with label values lvs.
+func (h *histogramVec) Observe(v float64, lvs ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist := h.get(lvs, func() *histogram {
+		return &histogram{counts: make([]uint64, len(h.buckets))}
+	})
+	for i, b := range h.buckets {
+		if v <= b {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+func (h *histogramVec) collect(w io.Writer) {
+	h.header(w, "histogram")
+	h.each(func(labels string, hist *histogram) {
+		lvs := strings.TrimSuffix(strings.TrimPrefix(labels, "{"), "}")
+		if lvs != "" {
+			lvs += ","
+		}
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d\n", h.name, lvs, b, hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", h.name, lvs, hist.count)
+		fmt.Fprintf(w, "%s_sum%s %g\n", h.name, labels, hist.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, hist.count)
+	})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders label names and values as {name="value",...}.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	parts := make([]string, len(names))
+	for i, n := range names {
+		parts[i] = n + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
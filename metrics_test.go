@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	hb "github.com/whyrusleeping/hellabot"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	commands := newCounterVec("test_commands_total", "Commands.", "command", "dispatch")
+	commands.Inc("pin", "accepted")
+	commands.Inc("pin", "accepted")
+	commands.Inc("befriend", "denied")
+	reconnects := newCounterVec("test_reconnects_total", "Reconnects.")
+	peers := newGaugeVec("test_peer_errors", "Errors.", "peer")
+	peers.Set(3, `peer "one"`)
+	peers.Set(0.5, "two\\three\nfour")
+	seconds := newHistogramVec("test_seconds", "Durations.", expBuckets(1, 2, 3), "peer")
+	seconds.Observe(0.5, "/dns4/one/tcp/9094")
+	seconds.Observe(3, "/dns4/one/tcp/9094")
+	seconds.Observe(10, "/dns4/one/tcp/9094")
+	unlabeled := newHistogramVec("test_unlabeled_seconds", "Unlabeled.", []float64{1})
+	unlabeled.Observe(1)
+	depth := gaugeFunc{"test_depth", "Depth.", func() float64 { return 7 }}
+
+	var buf bytes.Buffer
+	for _, c := range []collector{commands, reconnects, peers, seconds, unlabeled, depth} {
+		c.collect(&buf)
+	}
+
+	want, err := os.ReadFile("testdata/metrics/exposition.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(want) {
+		t.Errorf("exposition:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestCommandOutcomes(t *testing.T) {
+	withQueues(t)
+	pool = NewWorkerPool(1, 10)
+	oldPrefix, oldRoles := prefix, currentRoles()
+	prefix = "!"
+	setRoles(Roles{EveryoneRole: {allCommands}})
+
+	test := map[string]func(c Command, args []string){
+		"t-ok":     func(c Command, args []string) { reply(c, "done") },
+		"t-usage":  func(c Command, args []string) { usage(c, "usage: !t-usage <arg>") },
+		"t-refuse": func(c Command, args []string) { refuse(c, "you may not") },
+		"t-fail":   func(c Command, args []string) { fail(c, "failed") },
+		"t-queued": func(c Command, args []string) {
+			dispatch(c, func() { setOutcome(c, "failed") })
+		},
+	}
+	for name, h := range test {
+		handlers[name] = h
+	}
+	t.Cleanup(func() {
+		for name := range test {
+			delete(handlers, name)
+		}
+		prefix = oldPrefix
+		setRoles(oldRoles)
+	})
+
+	tests := []struct {
+		command, outcome string
+	}{
+		{"t-ok", "ok"},
+		{"t-usage", "usage"},
+		{"t-refuse", "refused"},
+		{"t-fail", "failed"},
+		// reported by the worker once the command has run
+		{"t-queued", "failed"},
+	}
+	for _, tt := range tests {
+		before := outcomeCount(tt.command, tt.outcome)
+		Handle(ircCommand{hb.ParseMessage(":alice!a@host PRIVMSG #pinbot :!" + tt.command)})
+		pool.Wait(time.Now().Add(5 * time.Second))
+		if got := outcomeCount(tt.command, tt.outcome); got != before+1 {
+			t.Errorf("%s: %s counted %g times, want %g", tt.command, tt.outcome, got, before+1)
+		}
+	}
+}
+
+// outcomeCount returns how many times command had outcome.
+func outcomeCount(command, outcome string) float64 {
+	c := commandOutcomesTotal
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.values[command+"\xff"+outcome]; ok {
+		return *v
+	}
+	return 0
+}
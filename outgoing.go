@@ -0,0 +1,361 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// Limiter is implemented by transports whose server limits how long
+// messages may be and how fast they may be sent.
+type Limiter interface {
+	// MaxLine returns the most bytes a single message to `to` may have.
+	MaxLine(to string) int
+	// Bucket returns the token bucket that messages take from.
+	Bucket() *TokenBucket
+}
+
+// Connector is implemented by transports that can only send while they are
+// connected. Lines for them wait in their queue until they are.
+type Connector interface {
+	Connected() bool
+}
+
+// reconnectPoll is how often the queue checks whether a transport that was
+// not connected is now.
+var reconnectPoll = time.Second
+
+// TokenBucket allows bursts of up to burst messages, refilled at one token
+// per interval.
+type TokenBucket struct {
+	mu       sync.Mutex
+	burst    int
+	interval time.Duration
+	tokens   float64
+	last     time.Time
+}
+
+func NewTokenBucket(burst int, interval time.Duration) *TokenBucket {
+	return &TokenBucket{
+		burst:    burst,
+		interval: interval,
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// Set changes the size and refill rate of the bucket.
+func (b *TokenBucket) Set(burst int, interval time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.burst = burst
+	b.interval = interval
+	b.tokens = min(b.tokens, float64(burst))
+}
+
+// refill adds the tokens earned since the last refill. It must be called
+// with mu held.
+func (b *TokenBucket) refill(now time.Time) {
+	b.tokens = min(float64(b.burst), b.tokens+float64(now.Sub(b.last))/float64(b.interval))
+	b.last = now
+}
+
+// Wait returns how long until a token is available, or 0 if one is now.
+func (b *TokenBucket) Wait() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(b.interval))
+}
+
+// Take uses up a token. It may leave the bucket in debt.
+func (b *TokenBucket) Take() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens--
+}
+
+// splitMessage breaks msg into lines and the lines into pieces of at most
+// max bytes, on word boundaries where possible. Empty lines are dropped.
+func splitMessage(msg string, max int) []string {
+	var out []string
+	for _, line := range strings.Split(msg, "\n") {
+		line = strings.TrimRight(line, "\r ")
+		for len(line) > max {
+			cut := strings.LastIndexByte(line[:max+1], ' ')
+			if cut <= 0 {
+				// no space to break at, so break the word, but not
+				// in the middle of a character
+				cut = max
+				for cut > 0 && !utf8.RuneStart(line[cut]) {
+					cut--
+				}
+				if cut == 0 {
+					cut = max
+				}
+			}
+			if piece := strings.TrimRight(line[:cut], " "); piece != "" {
+				out = append(out, piece)
+			}
+			line = strings.TrimLeft(line[cut:], " ")
+		}
+		if line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// outLine is a line waiting to be sent.
+type outLine struct {
+	seq    uint64
+	text   string
+	notice bool
+}
+
+// outTarget holds the lines waiting to be sent to one address.
+type outTarget struct {
+	lines []outLine
+}
+
+type flushRequest struct {
+	seq  uint64
+	done chan struct{}
+}
+
+// OutQueue hands the lines of queued messages to a sender per transport,
+// so that a transport that is slow or rate limited only holds up its own
+// lines.
+type OutQueue struct {
+	seq     uint64
+	senders map[string]*transportSender
+	depth   atomic.Int64
+}
+
+var outq = NewOutQueue()
+
+func NewOutQueue() *OutQueue {
+	return &OutQueue{senders: make(map[string]*transportSender)}
+}
+
+// Depth returns how many lines are waiting to be sent.
+func (q *OutQueue) Depth() int {
+	return int(q.depth.Load())
+}
+
+// add queues the lines of m, or a flush request if m is one. It never
+// waits for a transport.
+func (q *OutQueue) add(m msgWrap) {
+	if m.flushed != nil {
+		q.flush(m.flushed)
+		return
+	}
+
+	t, channel, err := resolve(m.actor)
+	if err != nil {
+		logger.Error("cannot deliver message", "channel", m.actor, "err", err)
+		return
+	}
+	lines := []string{m.message}
+	if l, ok := t.(Limiter); ok {
+		lines = splitMessage(m.message, l.MaxLine(channel))
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	out := make([]outLine, len(lines))
+	for i, line := range lines {
+		q.seq++
+		out[i] = outLine{seq: q.seq, text: line, notice: m.notice}
+	}
+	q.depth.Add(int64(len(out)))
+	q.sender(t).add(m.actor, out)
+}
+
+// sender returns the sender for t, starting it if there is none yet.
+func (q *OutQueue) sender(t Transport) *transportSender {
+	s, ok := q.senders[t.Name()]
+	if !ok {
+		s = &transportSender{
+			t:       t,
+			depth:   &q.depth,
+			wake:    make(chan struct{}, 1),
+			targets: make(map[string]*outTarget),
+		}
+		q.senders[t.Name()] = s
+		go s.run()
+	}
+	return s
+}
+
+// flush closes done once every line queued so far has been sent.
+func (q *OutQueue) flush(done chan struct{}) {
+	waits := make([]<-chan struct{}, 0, len(q.senders))
+	for _, s := range q.senders {
+		waits = append(waits, s.flush(q.seq))
+	}
+	go func() {
+		for _, w := range waits {
+			<-w
+		}
+		close(done)
+	}()
+}
+
+// transportSender sends the lines queued for the addresses of one
+// transport, each address in turn, as fast as the transport allows.
+type transportSender struct {
+	t     Transport
+	depth *atomic.Int64
+	wake  chan struct{}
+
+	mu      sync.Mutex
+	targets map[string]*outTarget
+	// order lists the addresses with lines waiting, next one first.
+	order   []string
+	flushes []flushRequest
+	// sending is the seq of the line being sent, or 0.
+	sending uint64
+}
+
+func (s *transportSender) add(addr string, lines []outLine) {
+	s.mu.Lock()
+	ot, ok := s.targets[addr]
+	if !ok {
+		ot = &outTarget{}
+		s.targets[addr] = ot
+		s.order = append(s.order, addr)
+	}
+	ot.lines = append(ot.lines, lines...)
+	s.mu.Unlock()
+	s.poke()
+}
+
+// poke wakes up the sender if it is waiting for lines.
+func (s *transportSender) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// flush returns a channel that is closed once the lines up to seq have been
+// sent.
+func (s *transportSender) flush(seq uint64) <-chan struct{} {
+	done := make(chan struct{})
+	s.mu.Lock()
+	s.flushes = append(s.flushes, flushRequest{seq: seq, done: done})
+	s.checkFlushes()
+	s.mu.Unlock()
+	return done
+}
+
+// run sends lines until the program exits.
+func (s *transportSender) run() {
+	timer := time.NewTimer(0)
+	for {
+		var due <-chan time.Time
+		if wait, ok := s.sendDue(); ok {
+			timer.Reset(wait)
+			due = timer.C
+		}
+
+		select {
+		case <-s.wake:
+		case <-due:
+		}
+	}
+}
+
+// sendDue sends lines in turn until the transport is out of tokens or not
+// connected. It returns how long until the next line may be sent, and false
+// if there are none left.
+func (s *transportSender) sendDue() (time.Duration, bool) {
+	for {
+		s.mu.Lock()
+		if len(s.order) == 0 {
+			s.mu.Unlock()
+			return 0, false
+		}
+		if c, ok := s.t.(Connector); ok && !c.Connected() {
+			s.mu.Unlock()
+			return reconnectPoll, true
+		}
+		l, limited := s.t.(Limiter)
+		if limited {
+			if w := l.Bucket().Wait(); w > 0 {
+				s.mu.Unlock()
+				return w, true
+			}
+		}
+		addr, line := s.next()
+		s.sending = line.seq
+		s.mu.Unlock()
+
+		if limited {
+			l.Bucket().Take()
+		}
+		sendMsg(addr, line.text, line.notice)
+		s.depth.Add(-1)
+
+		s.mu.Lock()
+		s.sending = 0
+		s.checkFlushes()
+		s.mu.Unlock()
+	}
+}
+
+// next takes the first line waiting for the address at the front of the
+// line and moves the address to the back. It must be called with mu held.
+func (s *transportSender) next() (string, outLine) {
+	addr := s.order[0]
+	ot := s.targets[addr]
+	line := ot.lines[0]
+	ot.lines = ot.lines[1:]
+
+	s.order = s.order[1:]
+	if len(ot.lines) > 0 {
+		s.order = append(s.order, addr)
+	} else {
+		delete(s.targets, addr)
+	}
+	return addr, line
+}
+
+// checkFlushes closes the flush requests whose lines have all been sent. It
+// must be called with mu held.
+func (s *transportSender) checkFlushes() {
+	oldest := uint64(math.MaxUint64)
+	if s.sending != 0 {
+		oldest = s.sending
+	}
+	for _, ot := range s.targets {
+		oldest = min(oldest, ot.lines[0].seq)
+	}
+	var waiting []flushRequest
+	for _, f := range s.flushes {
+		if f.seq < oldest {
+			close(f.done)
+		} else {
+			waiting = append(waiting, f)
+		}
+	}
+	s.flushes = waiting
+}
+
+// messageQueueProcess takes messages off msgs and hands them to the senders
+// of their transports.
+func messageQueueProcess() {
+	for m := range msgs {
+		outq.add(m)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(2, time.Hour)
+	for i := range 2 {
+		if w := b.Wait(); w != 0 {
+			t.Fatalf("token %d: Wait = %s, want 0", i+1, w)
+		}
+		b.Take()
+	}
+	if w := b.Wait(); w < 59*time.Minute || w > time.Hour {
+		t.Errorf("empty bucket: Wait = %s, want about an hour", w)
+	}
+
+	// taking without waiting runs the bucket into debt
+	b.Take()
+	if w := b.Wait(); w < 119*time.Minute || w > 2*time.Hour {
+		t.Errorf("bucket in debt: Wait = %s, want about two hours", w)
+	}
+
+	// a faster refill pays the debt off sooner, but does not make tokens
+	b.Set(3, time.Millisecond)
+	if w := b.Wait(); w > 2*time.Millisecond {
+		t.Errorf("after Set: Wait = %s, want at most 2ms", w)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if w := b.Wait(); w != 0 {
+		t.Errorf("after refilling: Wait = %s, want 0", w)
+	}
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	b := NewTokenBucket(3, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	// the bucket holds no more than burst tokens however long it waits
+	for range 3 {
+		b.Take()
+	}
+	if w := b.Wait(); w == 0 {
+		t.Error("bucket holds more than its burst")
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		msg  string
+		max  int
+		want []string
+	}{
+		{"hello world", 20, []string{"hello world"}},
+		{"hello world foo", 11, []string{"hello world", "foo"}},
+		{"word   word", 5, []string{"word", "word"}},
+		{"a\nb\n\nc", 10, []string{"a", "b", "c"}},
+		{"x\r\n", 10, []string{"x"}},
+		{"aaaaaaaaaa", 4, []string{"aaaa", "aaaa", "aa"}},
+		// é takes two bytes and is not split
+		{"héllo", 2, []string{"h", "é", "ll", "o"}},
+		{"", 10, nil},
+		{"\n\n", 10, nil},
+	}
+	for _, tt := range tests {
+		got := splitMessage(tt.msg, tt.max)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("splitMessage(%q, %d) = %q, want %q", tt.msg, tt.max, got, tt.want)
+		}
+		for _, line := range got {
+			if len(line) > tt.max {
+				t.Errorf("splitMessage(%q, %d): %q is longer than %d bytes", tt.msg, tt.max, line, tt.max)
+			}
+		}
+	}
+}
+
+// fakeTransport records what it is asked to send. Sends wait for block to
+// be closed, if it is set.
+type fakeTransport struct {
+	name      string
+	block     chan struct{}
+	connected *atomic.Bool
+
+	mu   sync.Mutex
+	sent []string
+}
+
+func (f *fakeTransport) Name() string {
+	return f.name
+}
+
+func (f *fakeTransport) Msg(to, msg string) {
+	f.record(to + " " + msg)
+}
+
+func (f *fakeTransport) Notice(to, msg string) {
+	f.record(to + " notice " + msg)
+}
+
+func (f *fakeTransport) record(line string) {
+	if f.block != nil {
+		<-f.block
+	}
+	f.mu.Lock()
+	f.sent = append(f.sent, line)
+	f.mu.Unlock()
+}
+
+func (f *fakeTransport) Sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.sent)
+}
+
+// waitSent waits until f has sent n lines.
+func (f *fakeTransport) waitSent(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sent := f.Sent()
+		if len(sent) >= n {
+			return sent
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s sent %q, want %d lines", f.name, sent, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// connectedTransport is a fakeTransport that is a Connector and a Limiter
+// with a bucket that never runs out.
+type connectedTransport struct {
+	*fakeTransport
+	bucket *TokenBucket
+}
+
+func (c connectedTransport) Connected() bool {
+	return c.connected.Load()
+}
+
+func (c connectedTransport) MaxLine(to string) int {
+	return 100
+}
+
+func (c connectedTransport) Bucket() *TokenBucket {
+	return c.bucket
+}
+
+func registerFake(t *testing.T, tr Transport) {
+	RegisterTransport(tr)
+	t.Cleanup(func() {
+		transportsMu.Lock()
+		delete(transports, tr.Name())
+		transportsMu.Unlock()
+	})
+}
+
+func TestOutQueueTransportsIndependent(t *testing.T) {
+	slow := &fakeTransport{name: "slow", block: make(chan struct{})}
+	fast := &fakeTransport{name: "fast"}
+	registerFake(t, slow)
+	registerFake(t, fast)
+
+	q := NewOutQueue()
+	q.add(msgWrap{actor: "slow:#a", message: "one"})
+	q.add(msgWrap{actor: "fast:#b", message: "two"})
+	q.add(msgWrap{actor: "fast:#b", message: "three", notice: true})
+
+	// the fast transport is not held up by the slow one
+	want := []string{"#b two", "#b notice three"}
+	if got := fast.waitSent(t, 2); !slices.Equal(got, want) {
+		t.Errorf("fast sent %q, want %q", got, want)
+	}
+
+	flushed := make(chan struct{})
+	q.add(msgWrap{flushed: flushed})
+	select {
+	case <-flushed:
+		t.Fatal("flushed while a line was still being sent")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(slow.block)
+	select {
+	case <-flushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not flushed after every line was sent")
+	}
+	if got := slow.Sent(); !slices.Equal(got, []string{"#a one"}) {
+		t.Errorf("slow sent %q", got)
+	}
+	if d := q.Depth(); d != 0 {
+		t.Errorf("Depth = %d after flushing, want 0", d)
+	}
+}
+
+func TestOutQueueTakesTurns(t *testing.T) {
+	old := reconnectPoll
+	reconnectPoll = time.Millisecond
+	t.Cleanup(func() { reconnectPoll = old })
+
+	ft := &fakeTransport{name: "turns", connected: new(atomic.Bool)}
+	registerFake(t, connectedTransport{ft, NewTokenBucket(100, time.Millisecond)})
+
+	// lines wait while the transport is not connected
+	q := NewOutQueue()
+	q.add(msgWrap{actor: "turns:#a", message: "a1\na2\na3"})
+	q.add(msgWrap{actor: "turns:#b", message: "b1"})
+	time.Sleep(10 * time.Millisecond)
+	if sent := ft.Sent(); len(sent) > 0 {
+		t.Fatalf("sent %q while not connected", sent)
+	}
+	if d := q.Depth(); d != 4 {
+		t.Errorf("Depth = %d, want 4", d)
+	}
+
+	ft.connected.Store(true)
+	want := []string{"#a a1", "#b b1", "#a a2", "#a a3"}
+	if got := ft.waitSent(t, 4); !slices.Equal(got, want) {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+}
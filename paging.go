@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	// morePageSize is how many lines of a long reply are sent at once.
+	morePageSize = 10
+	// moreExpiry is how long the rest of a long reply is kept for !more.
+	moreExpiry = 30 * time.Minute
+)
+
+// Pager sends long replies a page at a time and keeps the rest of each, one
+// per address, for the more command.
+type Pager struct {
+	mu      sync.Mutex
+	pending map[string]*pagedReply
+}
+
+type pagedReply struct {
+	lines   []string
+	expires time.Time
+}
+
+var pager = &Pager{pending: make(map[string]*pagedReply)}
+
+// Send sends the first page of lines to actor, replacing whatever was left
+// of the previous long reply there.
+func (p *Pager) Send(actor string, lines []string) {
+	p.mu.Lock()
+	p.pending[actor] = &pagedReply{
+		lines:   slices.Clone(lines),
+		expires: time.Now().Add(moreExpiry),
+	}
+	p.mu.Unlock()
+	p.More(actor)
+}
+
+// More sends the next page of the last long reply to actor.
+func (p *Pager) More(actor string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pr, ok := p.pending[actor]
+	if !ok || time.Now().After(pr.expires) {
+		delete(p.pending, actor)
+		botMsg(actor, "there is nothing more to show")
+		return
+	}
+
+	n := min(morePageSize, len(pr.lines))
+	for _, line := range pr.lines[:n] {
+		botMsg(actor, line)
+	}
+	pr.lines = pr.lines[n:]
+	if len(pr.lines) == 0 {
+		delete(p.pending, actor)
+		return
+	}
+	botMsg(actor, fmt.Sprintf("%d more lines, say %s%s", len(pr.lines), prefix, cmdMore))
+}
+
+// cutFlag removes flag from args and reports whether it was there.
+func cutFlag(args []string, flag string) ([]string, bool) {
+	i := slices.Index(args, flag)
+	if i < 0 {
+		return args, false
+	}
+	return slices.Delete(slices.Clone(args), i, i+1), true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPager(t *testing.T) {
+	withQueues(t)
+	oldPrefix := prefix
+	prefix = "!"
+	t.Cleanup(func() { prefix = oldPrefix })
+
+	lines := func(from, to int) []string {
+		var out []string
+		for i := from; i <= to; i++ {
+			out = append(out, fmt.Sprintf("line %d", i))
+		}
+		return out
+	}
+	p := &Pager{pending: make(map[string]*pagedReply)}
+	tests := []struct {
+		step  func()
+		actor string
+		want  []string
+	}{
+		{func() { p.Send("#pinbot", lines(1, 23)) }, "#pinbot", append(lines(1, 10), "13 more lines, say !more")},
+		// a long reply elsewhere does not get in the way
+		{func() { p.Send("alice", lines(1, 3)) }, "alice", lines(1, 3)},
+		{func() { p.More("#pinbot") }, "#pinbot", append(lines(11, 20), "3 more lines, say !more")},
+		{func() { p.More("#pinbot") }, "#pinbot", lines(21, 23)},
+		{func() { p.More("#pinbot") }, "#pinbot", []string{"there is nothing more to show"}},
+		{func() { p.More("alice") }, "alice", []string{"there is nothing more to show"}},
+		// a new long reply replaces what was left of the last one
+		{func() { p.Send("#pinbot", lines(1, 12)) }, "#pinbot", append(lines(1, 10), "2 more lines, say !more")},
+		{func() { p.Send("#pinbot", lines(31, 42)) }, "#pinbot", append(lines(31, 40), "2 more lines, say !more")},
+		{func() { p.More("#pinbot") }, "#pinbot", lines(41, 42)},
+		// what is left is forgotten after moreExpiry
+		{func() {
+			p.Send("#pinbot", lines(1, 12))
+			p.pending["#pinbot"].expires = time.Now().Add(-time.Second)
+		}, "#pinbot", append(lines(1, 10), "2 more lines, say !more")},
+		{func() { p.More("#pinbot") }, "#pinbot", []string{"there is nothing more to show"}},
+	}
+	for i, tt := range tests {
+		tt.step()
+		var got []string
+		for _, m := range queued() {
+			if m.actor != tt.actor {
+				t.Errorf("%d: %q sent to %s, want %s", i, m.message, m.actor, tt.actor)
+			}
+			got = append(got, m.message)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d: sent %q, want %q", i, got, tt.want)
+		}
+	}
+}
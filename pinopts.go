@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// PinOpts are the cluster settings a pin may ask for instead of the
+// cluster's defaults. Allocations are peer names or IDs. Metadata is the
+// user's own; pinbot adds its own keys when pinning.
+type PinOpts struct {
+	ReplicationMin int               `json:"rmin,omitempty"`
+	ReplicationMax int               `json:"rmax,omitempty"`
+	Allocations    []string          `json:"allocations,omitempty"`
+	ExpireAt       time.Time         `json:"expire_at,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	// TTL has pinbot unpin the item once it is up.
+	TTL Duration `json:"ttl,omitzero"`
+}
+
+// IsZero reports whether o leaves everything to the cluster.
+func (o PinOpts) IsZero() bool {
+	return o.ReplicationMin == 0 && o.ReplicationMax == 0 &&
+		len(o.Allocations) == 0 && o.ExpireAt.IsZero() && len(o.Metadata) == 0 && o.TTL.Duration == 0
+}
+
+// String describes o as it is echoed back in chat.
+func (o PinOpts) String() string {
+	var parts []string
+	if o.ReplicationMin != 0 {
+		parts = append(parts, fmt.Sprintf("rmin %d", o.ReplicationMin))
+	}
+	if o.ReplicationMax != 0 {
+		parts = append(parts, fmt.Sprintf("rmax %d", o.ReplicationMax))
+	}
+	if len(o.Allocations) > 0 {
+		parts = append(parts, "allocations "+strings.Join(o.Allocations, ","))
+	}
+	if !o.ExpireAt.IsZero() {
+		parts = append(parts, "expires "+o.ExpireAt.UTC().Format("2006-01-02 15:04"))
+	}
+	if o.TTL.Duration != 0 {
+		parts = append(parts, "ttl "+formatDuration(o.TTL.Duration))
+	}
+	if len(o.Metadata) > 0 {
+		parts = append(parts, formatMeta(o.Metadata))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Validate checks that the replication factors make sense together, -1
+// meaning everywhere, and that the expiry lies ahead.
+func (o PinOpts) Validate() error {
+	for _, r := range []int{o.ReplicationMin, o.ReplicationMax} {
+		if r < -1 {
+			return fmt.Errorf("invalid replication factor: %d", r)
+		}
+	}
+	if o.ReplicationMin > 0 && o.ReplicationMax > 0 && o.ReplicationMin > o.ReplicationMax {
+		return errors.New("rmin must not be greater than rmax")
+	}
+	if o.ReplicationMin == -1 && o.ReplicationMax > 0 {
+		return errors.New("rmin -1 (everywhere) needs rmax -1 too")
+	}
+	if !o.ExpireAt.IsZero() && o.ExpireAt.Before(time.Now()) {
+		return errors.New("expiry is in the past")
+	}
+	if o.TTL.Duration < 0 {
+		return errors.New("ttl must be positive")
+	}
+	for _, a := range o.Allocations {
+		if a == "" {
+			return errors.New("empty peer in allocations")
+		}
+	}
+	for k := range o.Metadata {
+		if !metaKey.MatchString(k) || strings.HasPrefix(k, metaPrefix) {
+			return fmt.Errorf("invalid metadata key: %s", k)
+		}
+	}
+	return nil
+}
+
+// Allowed checks that s may ask for o. An expiry or a time to live unpins
+// the item later, for everyone who pinned it, so they take the permission
+// to unpin.
+func (o PinOpts) Allowed(s Sender) error {
+	if (!o.ExpireAt.IsZero() || o.TTL.Duration != 0) && !friends.Can(s, cmdUnPin) {
+		return errors.New("an expiry or ttl unpins the item later, which takes the permission to unpin")
+	}
+	return nil
+}
+
+// parsePinArgs splits the arguments of the pin command into the path, the
+// label and the options: those that follow --rmin, --rmax, --allocations,
+// --expire, --ttl and --meta, wherever they appear. --meta takes key=value
+// and may be given more than once.
+func parsePinArgs(args []string, now time.Time) (path, label string, opts PinOpts, err error) {
+	var words []string
+	for i := 0; i < len(args); i++ {
+		name, ok := strings.CutPrefix(args[i], "--")
+		if !ok {
+			words = append(words, args[i])
+			continue
+		}
+		if i+1 == len(args) {
+			return "", "", opts, fmt.Errorf("--%s needs a value", name)
+		}
+		i++
+		value := args[i]
+
+		switch name {
+		case "rmin", "rmax":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return "", "", opts, fmt.Errorf("invalid --%s: %s", name, value)
+			}
+			if name == "rmin" {
+				opts.ReplicationMin = n
+			} else {
+				opts.ReplicationMax = n
+			}
+		case "allocations":
+			opts.Allocations = strings.Split(value, ",")
+		case "ttl":
+			opts.TTL.Duration, err = parseDuration(value)
+			if err != nil {
+				return "", "", opts, fmt.Errorf("invalid --ttl: %s", value)
+			}
+		case "expire":
+			opts.ExpireAt, err = parseExpiry(value, now)
+			if err != nil {
+				return "", "", opts, fmt.Errorf("invalid --expire: %s", err)
+			}
+		case "meta":
+			k, v, ok := parseMeta(value)
+			if !ok {
+				return "", "", opts, fmt.Errorf("invalid --meta: %s, want key=value", value)
+			}
+			if opts.Metadata == nil {
+				opts.Metadata = make(map[string]string)
+			}
+			opts.Metadata[k] = v
+		default:
+			return "", "", opts, fmt.Errorf("unknown option: --%s", name)
+		}
+	}
+
+	if len(words) < 2 {
+		return "", "", opts, errors.New("need a hash and a label")
+	}
+	if err := opts.Validate(); err != nil {
+		return "", "", opts, err
+	}
+	return words[0], strings.Join(words[1:], " "), opts, nil
+}
+
+// apiOptions returns the cluster pin options for the pin recorded in e,
+// looking up the IDs of the allocated peers.
+func (o PinOpts) apiOptions(ctx context.Context, e *JournalEntry) (api.PinOptions, error) {
+	po := api.PinOptions{
+		Name:                 e.Label,
+		ReplicationFactorMin: o.ReplicationMin,
+		ReplicationFactorMax: o.ReplicationMax,
+		ExpireAt:             o.ExpireAt,
+		Metadata:             pinMetadata(e),
+	}
+	if len(o.Allocations) == 0 {
+		return po, nil
+	}
+
+	peers, err := clients.Load().lbClient.Peers(ctx)
+	if err != nil {
+		return po, fmt.Errorf("could not list cluster peers: %s", err)
+	}
+	for _, a := range o.Allocations {
+		found := false
+		for _, p := range peers {
+			if p.Peername == a || p.ID.Pretty() == a {
+				po.UserAllocations = append(po.UserAllocations, p.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return po, fmt.Errorf("unknown cluster peer: %s", a)
+		}
+	}
+	return po, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePinArgs(t *testing.T) {
+	const c = "QmbTdsZpRdVC7au7jLtkMwD6PRJPvfPvdRzG817PnxR2pR"
+	now := time.Now()
+	tests := []struct {
+		args  []string
+		path  string
+		label string
+		opts  PinOpts
+	}{
+		{args: []string{c, "my", "site"}, path: c, label: "my site"},
+		{
+			args: []string{c, "site", "--rmin", "2", "--rmax", "3"},
+			path: c, label: "site",
+			opts: PinOpts{ReplicationMin: 2, ReplicationMax: 3},
+		},
+		{
+			args: []string{"--rmin", "-1", "--rmax", "-1", c, "site"},
+			path: c, label: "site",
+			opts: PinOpts{ReplicationMin: -1, ReplicationMax: -1},
+		},
+		{
+			args: []string{c, "--allocations", "peerA,peerB", "site"},
+			path: c, label: "site",
+			opts: PinOpts{Allocations: []string{"peerA", "peerB"}},
+		},
+		{
+			args: []string{c, "site", "--expire", "30d"},
+			path: c, label: "site",
+			opts: PinOpts{ExpireAt: now.Add(30 * day)},
+		},
+		{
+			args: []string{c, "site", "--ttl", "14d"},
+			path: c, label: "site",
+			opts: PinOpts{TTL: Duration{14 * day}},
+		},
+		{
+			args: []string{c, "site", "--meta", "team=infra", "--meta", "env=prod"},
+			path: c, label: "site",
+			opts: PinOpts{Metadata: map[string]string{"team": "infra", "env": "prod"}},
+		},
+		{
+			args: []string{"--meta", "team=infra", c, "site"},
+			path: c, label: "site",
+			opts: PinOpts{Metadata: map[string]string{"team": "infra"}},
+		},
+		{
+			// without --meta, key=value is part of the label
+			args: []string{c, "build", "v=1.2"},
+			path: c, label: "build v=1.2",
+		},
+	}
+	for _, tt := range tests {
+		path, label, opts, err := parsePinArgs(tt.args, now)
+		if err != nil {
+			t.Errorf("parsePinArgs(%q): %s", tt.args, err)
+			continue
+		}
+		if path != tt.path || label != tt.label || !reflect.DeepEqual(opts, tt.opts) {
+			t.Errorf("parsePinArgs(%q) = %q, %q, %+v, want %q, %q, %+v",
+				tt.args, path, label, opts, tt.path, tt.label, tt.opts)
+		}
+	}
+
+	bad := [][]string{
+		{c},
+		{c, "site", "--rmin"},
+		{c, "site", "--rmin", "two"},
+		{c, "site", "--bogus", "1"},
+		{c, "site", "--rmin", "3", "--rmax", "2"},
+		{c, "site", "--rmin", "-2"},
+		{c, "site", "--rmin", "-1", "--rmax", "2"},
+		{c, "site", "--allocations", "peerA,,peerB"},
+		{c, "site", "--meta", "pinbot.nick=mallory"},
+		{c, "site", "--meta", "team"},
+		{c, "site", "--meta", "=infra"},
+		{c, "site", "--expire", "2001-01-01"},
+		{c, "site", "--expire", "whenever"},
+		{c, "site", "--ttl", "-1h"},
+	}
+	for _, args := range bad {
+		if _, _, opts, err := parsePinArgs(args, now); err == nil {
+			t.Errorf("parsePinArgs(%q) = %+v, want an error", args, opts)
+		}
+	}
+}
+
+// withFriends makes fl the friends list for the rest of the test.
+func withFriends(t *testing.T, fl map[string]Friend) {
+	old := friends.All()
+	friends.Set(fl)
+	t.Cleanup(func() { friends.Set(old) })
+}
+
+func TestPinOptsAllowed(t *testing.T) {
+	withFriends(t, map[string]Friend{
+		"pina":  {Name: "pina", Role: "pinner", Insecure: true},
+		"admin": {Name: "admin", Role: AdminRole, Insecure: true},
+	})
+	expire := PinOpts{ExpireAt: time.Now().Add(day)}
+	tests := []struct {
+		nick string
+		opts PinOpts
+		ok   bool
+	}{
+		{"pina", PinOpts{}, true},
+		{"pina", PinOpts{ReplicationMin: 2, Metadata: map[string]string{"team": "infra"}}, true},
+		// re-pinning someone else's item with an expiry would unpin it
+		{"pina", expire, false},
+		{"stranger", expire, false},
+		{"admin", expire, true},
+		{"pina", PinOpts{TTL: Duration{time.Hour}}, false},
+		{"admin", PinOpts{TTL: Duration{time.Hour}}, true},
+	}
+	for _, tt := range tests {
+		err := tt.opts.Allowed(Sender{Nick: tt.nick})
+		if tt.ok && err != nil {
+			t.Errorf("%s with %s: %s", tt.nick, tt.opts, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s with %s: allowed, want an error", tt.nick, tt.opts)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Reloader re-reads the config file, the roles and the friends list while
+// the bot keeps running.
+type Reloader struct {
+	mu   sync.Mutex
+	file string
+	// overrides applies the command-line flags on top of the config file.
+	overrides func(*Config)
+	// cfg holds the settings in effect.
+	cfg *Config
+}
+
+var reloader *Reloader
+
+// Reload reads everything again and, only if all of it is valid, swaps in
+// the new cluster clients, webhooks, roles and friends. It returns a
+// description of each change.
+func (rl *Reloader) Reload() ([]string, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	cfg, err := LoadConfig(rl.file)
+	if err != nil {
+		return nil, err
+	}
+	rl.overrides(cfg)
+	for _, err := range cfg.warnings {
+		logger.Warn("config", "err", err)
+	}
+	if errs := cfg.Validate(); len(errs) > 0 {
+		err := fmt.Errorf("%s: %s", rl.file, errs[0])
+		if len(errs) > 1 {
+			err = fmt.Errorf("%s (and %d more problems)", err, len(errs)-1)
+		}
+		return nil, err
+	}
+
+	rs, err := LoadRoles(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []string
+	err = friends.Reload(rs, func(cur, f map[string]Friend) error {
+		cl, err := setupClients(cfg)
+		if err != nil {
+			return err
+		}
+
+		changes = append(changes, diffPeers(rl.cfg, cfg)...)
+		changes = append(changes, diffRoles(currentRoles(), rs)...)
+		changes = append(changes, diffFriends(cur, f)...)
+		if !reflect.DeepEqual(rl.cfg.Webhooks, cfg.Webhooks) {
+			changes = append(changes, "changed webhooks")
+		}
+		changes = append(changes, needRestart(rl.cfg, cfg)...)
+
+		clients.Store(cl)
+		outbox.SetTargets(cfg.Webhooks)
+		setRoles(rs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	rl.cfg = cfg
+	return changes, nil
+}
+
+func diffPeers(old, cfg *Config) []string {
+	var changes []string
+	if old.Cluster.Username != cfg.Cluster.Username ||
+		old.Cluster.Password != cfg.Cluster.Password ||
+		old.Cluster.Retries != cfg.Cluster.Retries {
+		changes = append(changes, "changed cluster credentials or retries")
+	}
+
+	peers := func(cfg *Config) map[string]PeerConfig {
+		m := make(map[string]PeerConfig)
+		for _, p := range cfg.Cluster.Peers {
+			m[p.Addr] = p
+		}
+		return m
+	}
+	was, now := peers(old), peers(cfg)
+	for _, addr := range slices.Sorted(maps.Keys(now)) {
+		p, ok := was[addr]
+		switch {
+		case !ok:
+			changes = append(changes, "added cluster peer "+addr)
+		case p != now[addr]:
+			changes = append(changes, "changed cluster peer "+addr)
+		}
+	}
+	for _, addr := range slices.Sorted(maps.Keys(was)) {
+		if _, ok := now[addr]; !ok {
+			changes = append(changes, "removed cluster peer "+addr)
+		}
+	}
+
+	hosts := func(cfg *Config) map[string]bool {
+		m := make(map[string]bool)
+		for _, h := range cfg.Hosts {
+			m[h] = true
+		}
+		return m
+	}
+	wasHosts, nowHosts := hosts(old), hosts(cfg)
+	for _, h := range slices.Sorted(maps.Keys(nowHosts)) {
+		if !wasHosts[h] {
+			changes = append(changes, "added host "+h)
+		}
+	}
+	for _, h := range slices.Sorted(maps.Keys(wasHosts)) {
+		if !nowHosts[h] {
+			changes = append(changes, "removed host "+h)
+		}
+	}
+	return changes
+}
+
+func diffRoles(old, r Roles) []string {
+	var changes []string
+	for _, role := range slices.Sorted(maps.Keys(r)) {
+		cmds, ok := old[role]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("added role %s: %s", role, strings.Join(r[role], " ")))
+		case !reflect.DeepEqual(cmds, r[role]):
+			changes = append(changes, fmt.Sprintf("changed role %s: %s", role, strings.Join(r[role], " ")))
+		}
+	}
+	for _, role := range slices.Sorted(maps.Keys(old)) {
+		if _, ok := r[role]; !ok {
+			changes = append(changes, "removed role "+role)
+		}
+	}
+	return changes
+}
+
+func diffFriends(old, f map[string]Friend) []string {
+	var changes []string
+	for _, name := range slices.Sorted(maps.Keys(f)) {
+		fr, ok := old[name]
+		switch {
+		case !ok:
+			changes = append(changes, "added friend "+f[name].String())
+		case fr.String() != f[name].String():
+			changes = append(changes, "changed friend "+f[name].String())
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(old)) {
+		if _, ok := f[name]; !ok {
+			changes = append(changes, "removed friend "+name)
+		}
+	}
+	return changes
+}
+
+// needRestart lists the settings that changed but only take effect on the
+// next start.
+func needRestart(old, cfg *Config) []string {
+	var fields []string
+	check := func(changed bool, field string) {
+		if changed {
+			fields = append(fields, field)
+		}
+	}
+	check(old.Name != cfg.Name, "name")
+	check(old.Server != cfg.Server, "server")
+	check(old.Channel != cfg.Channel, "channel")
+	check(old.Prefix != cfg.Prefix, "prefix")
+	check(old.Gateway != cfg.Gateway, "gateway")
+	check(!reflect.DeepEqual(old.Matrix, cfg.Matrix), "matrix")
+	check(!reflect.DeepEqual(old.API, cfg.API), "api")
+	check(old.Metrics != cfg.Metrics, "metrics")
+	check(!reflect.DeepEqual(old.Reports, cfg.Reports), "reports")
+	check(!reflect.DeepEqual(old.Log, cfg.Log), "log")
+	check(old.Flood != cfg.Flood, "flood")
+	check(old.Files != cfg.Files, "files")
+	check(old.Workers != cfg.Workers, "workers")
+	check(old.Queue != cfg.Queue, "queue")
+	check(old.JobTimeout != cfg.JobTimeout, "job_timeout")
+	if len(fields) == 0 {
+		return nil
+	}
+	return []string{"changed " + strings.Join(fields, ", ") + " (needs a restart)"}
+}
+
+// ReloadCmd reloads and reports the outcome to actor.
+func ReloadCmd(actor string) {
+	changes, err := reloader.Reload()
+	if err != nil {
+		logger.Error("reload failed", "err", err)
+		botMsg(actor, "reload failed, keeping the old settings: "+err.Error())
+		return
+	}
+
+	logger.Info("reloaded", "changes", strings.Join(changes, "; "))
+	if len(changes) == 0 {
+		botMsg(actor, "reloaded, nothing changed")
+		return
+	}
+	botMsg(actor, fmt.Sprintf("reloaded, %d changes:", len(changes)))
+	for _, c := range changes {
+		botMsg(actor, c)
+	}
+}
+
+// reloadOnHangup reloads on every SIGHUP and reports to channel.
+func reloadOnHangup(channel string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		logger.Info("got SIGHUP, reloading")
+		ReloadCmd(channel)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffPeers(t *testing.T) {
+	tests := []struct {
+		change func(*Config)
+		want   []string
+	}{
+		{func(cfg *Config) {}, nil},
+		{func(cfg *Config) { cfg.Cluster.Password = "secret" }, []string{"changed cluster credentials or retries"}},
+		{func(cfg *Config) {
+			cfg.Cluster.Peers = append(cfg.Cluster.Peers[1:], PeerConfig{Addr: "/dns4/three/tcp/9094"})
+			cfg.Cluster.Peers[0].SSL = true
+		}, []string{"added cluster peer /dns4/three/tcp/9094", "changed cluster peer /dns4/two/tcp/9094", "removed cluster peer /dns4/one/tcp/9094"}},
+		// only the set of hosts matters, not their order
+		{func(cfg *Config) { cfg.Hosts = []string{"/ip4/10.0.0.2/tcp/5001", "/ip4/10.0.0.1/tcp/5001"} }, nil},
+		{func(cfg *Config) { cfg.Hosts = []string{"/ip4/10.0.0.3/tcp/5001"} },
+			[]string{"added host /ip4/10.0.0.3/tcp/5001", "removed host /ip4/10.0.0.1/tcp/5001", "removed host /ip4/10.0.0.2/tcp/5001"}},
+	}
+	for i, tt := range tests {
+		old, cfg := reloadConfig(), reloadConfig()
+		tt.change(cfg)
+		if got := diffPeers(old, cfg); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d: diffPeers = %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestDiffRoles(t *testing.T) {
+	old := Roles{"pinner": {"pin", "unpin"}, "viewer": {"pins"}}
+	tests := []struct {
+		roles Roles
+		want  []string
+	}{
+		{Roles{"pinner": {"pin", "unpin"}, "viewer": {"pins"}}, nil},
+		{Roles{"pinner": {"pin"}, "viewer": {"pins"}, "admin": {"reload"}},
+			[]string{"added role admin: reload", "changed role pinner: pin"}},
+		{Roles{"pinner": {"pin", "unpin"}}, []string{"removed role viewer"}},
+	}
+	for i, tt := range tests {
+		if got := diffRoles(old, tt.roles); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d: diffRoles = %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestDiffFriends(t *testing.T) {
+	old := map[string]Friend{
+		"alice": {Name: "alice", Role: "admin", Account: "alice"},
+		"bob":   {Name: "bob", Role: "pinner"},
+	}
+	expires := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		friends map[string]Friend
+		want    []string
+	}{
+		{map[string]Friend{
+			"alice": {Name: "alice", Role: "admin", Account: "alice"},
+			"bob":   {Name: "bob", Role: "pinner"},
+		}, nil},
+		{map[string]Friend{
+			"alice": {Name: "alice", Role: "admin", Account: "alice"},
+			"bob":   {Name: "bob", Role: "pinner", Expires: expires},
+			"carol": {Name: "carol", Role: "viewer", Hostmask: "*@example.org"},
+		}, []string{"changed friend bob pinner expires=2026-11-01T00:00:00Z", "added friend carol viewer host=*@example.org"}},
+		{map[string]Friend{
+			"bob": {Name: "bob", Role: "pinner"},
+		}, []string{"removed friend alice"}},
+	}
+	for i, tt := range tests {
+		if got := diffFriends(old, tt.friends); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d: diffFriends = %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestNeedRestart(t *testing.T) {
+	tests := []struct {
+		change func(*Config)
+		want   []string
+	}{
+		{func(cfg *Config) {}, nil},
+		// these take effect at once
+		{func(cfg *Config) {
+			cfg.Hosts = nil
+			cfg.Roles = Roles{"pinner": {"pin"}}
+			cfg.Webhooks = []WebhookConfig{{URL: "https://example.org/hook", Secret: "s"}}
+		}, nil},
+		{func(cfg *Config) { cfg.Channel = "#elsewhere" }, []string{"changed channel (needs a restart)"}},
+		{func(cfg *Config) {
+			cfg.Name = "pinbot2"
+			cfg.Log.Level = "debug"
+			cfg.Files.Journal = "journal.jsonl"
+			cfg.JobTimeout = Duration{time.Minute}
+		}, []string{"changed name, log, files, job_timeout (needs a restart)"}},
+		{func(cfg *Config) { cfg.API = &APIConfig{Listen: ":8080"} }, []string{"changed api (needs a restart)"}},
+	}
+	for i, tt := range tests {
+		old, cfg := reloadConfig(), reloadConfig()
+		tt.change(cfg)
+		if got := needRestart(old, cfg); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d: needRestart = %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+// reloadConfig returns a config with two cluster peers and two hosts.
+func reloadConfig() *Config {
+	cfg := DefaultConfig()
+	cfg.Cluster.Peers = []PeerConfig{{Addr: "/dns4/one/tcp/9094"}, {Addr: "/dns4/two/tcp/9094"}}
+	cfg.Hosts = []string{"/ip4/10.0.0.1/tcp/5001", "/ip4/10.0.0.2/tcp/5001"}
+	return cfg
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReportThreshold = 20
+	defaultReportExpiry    = 24 * time.Hour
+)
+
+// ReportServer serves replies too long for chat as web pages, each under
+// an unguessable URL that stops working after a while.
+type ReportServer struct {
+	cfg ReportsConfig
+
+	mu      sync.Mutex
+	reports map[string]*report
+}
+
+type report struct {
+	Title   string
+	Lines   []string
+	Created time.Time
+	Expires time.Time
+}
+
+// reports is nil unless reports are configured.
+var reports *ReportServer
+
+func NewReportServer(cfg ReportsConfig) *ReportServer {
+	if cfg.URL == "" {
+		cfg.URL = "http://" + cfg.Listen
+	}
+	if cfg.Threshold == 0 {
+		cfg.Threshold = defaultReportThreshold
+	}
+	if cfg.Expiry.Duration == 0 {
+		cfg.Expiry.Duration = defaultReportExpiry
+	}
+	return &ReportServer{
+		cfg:     cfg,
+		reports: make(map[string]*report),
+	}
+}
+
+// Add keeps lines as a report and returns its URL.
+func (rs *ReportServer) Add(title string, lines []string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	now := time.Now()
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for k, rep := range rs.reports {
+		if now.After(rep.Expires) {
+			delete(rs.reports, k)
+		}
+	}
+	rs.reports[id] = &report{
+		Title:   title,
+		Lines:   lines,
+		Created: now.UTC(),
+		Expires: now.Add(rs.cfg.Expiry.Duration).UTC(),
+	}
+	return strings.TrimSuffix(rs.cfg.URL, "/") + "/r/" + id
+}
+
+func (rs *ReportServer) get(id string) (*report, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rep, ok := rs.reports[id]
+	if ok && time.Now().After(rep.Expires) {
+		delete(rs.reports, id)
+		return nil, false
+	}
+	return rep, ok
+}
+
+var reportPage = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{len .Lines}} lines, created {{.Created.Format "2006-01-02 15:04 MST"}}, available until {{.Expires.Format "2006-01-02 15:04 MST"}}.</p>
+<pre>
+{{range .Lines}}{{.}}
+{{end}}</pre>
+</body>
+</html>
+`))
+
+// Serve serves the reports on ln until it fails.
+func (rs *ReportServer) Serve(ln net.Listener) error {
+	srv := &http.Server{
+		Handler:           rs.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return srv.Serve(ln)
+}
+
+// handler serves /r/<id> as HTML and /r/<id>/text as plain text.
+func (rs *ReportServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /r/{id}", func(w http.ResponseWriter, r *http.Request) {
+		rep, ok := rs.get(r.PathValue("id"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("X-Robots-Tag", "noindex")
+		reportPage.Execute(w, rep)
+	})
+	mux.HandleFunc("GET /r/{id}/text", func(w http.ResponseWriter, r *http.Request) {
+		rep, ok := rs.get(r.PathValue("id"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Robots-Tag", "noindex")
+		fmt.Fprintln(w, rep.Title)
+		fmt.Fprintln(w)
+		for _, line := range rep.Lines {
+			fmt.Fprintln(w, line)
+		}
+	})
+	return mux
+}
+
+// offload reports whether lines are too many for chat and should be sent
+// as a report instead.
+func offload(lines int) bool {
+	return reports != nil && lines > reports.cfg.Threshold
+}
+
+// sendLong sends lines to actor, a page at a time or, when there are too
+// many, as a link to a report titled title.
+func sendLong(actor, title string, lines []string) {
+	if !offload(len(lines)) {
+		pager.Send(actor, lines)
+		return
+	}
+	url := reports.Add(title, lines)
+	botMsg(actor, fmt.Sprintf("%s (%d lines): %s", title, len(lines), url))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReports(t *testing.T) {
+	rs := NewReportServer(ReportsConfig{Listen: "127.0.0.1:9099", URL: "https://pinbot.example.org/", Expiry: Duration{time.Hour}})
+	srv := httptest.NewServer(rs.handler())
+	defer srv.Close()
+
+	url := rs.Add("3 pins found", []string{"one <b>", "two", "three"})
+	id, ok := strings.CutPrefix(url, "https://pinbot.example.org/r/")
+	if !ok || len(id) != 32 {
+		t.Fatalf("report URL %s, want one under the configured URL", url)
+	}
+	expired := rs.Add("old", []string{"gone"})
+	expiredID := expired[strings.LastIndex(expired, "/")+1:]
+	rs.reports[expiredID].Expires = time.Now().Add(-time.Second)
+
+	tests := []struct {
+		path string
+		code int
+		body []string
+	}{
+		{"/r/" + id, http.StatusOK, []string{"<title>3 pins found</title>", "one &lt;b&gt;\ntwo\nthree\n", "3 lines"}},
+		{"/r/" + id + "/text", http.StatusOK, []string{"3 pins found\n\none <b>\ntwo\nthree\n"}},
+		{"/r/" + expiredID, http.StatusNotFound, nil},
+		{"/r/" + expiredID + "/text", http.StatusNotFound, nil},
+		{"/r/0123456789abcdef0123456789abcdef", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(srv.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Errorf("GET %s = %d, want %d", tt.path, resp.StatusCode, tt.code)
+			continue
+		}
+		for _, want := range tt.body {
+			if !strings.Contains(string(buf), want) {
+				t.Errorf("GET %s = %q, want it to contain %q", tt.path, buf, want)
+			}
+		}
+		if tt.code == http.StatusOK && resp.Header.Get("X-Robots-Tag") != "noindex" {
+			t.Errorf("GET %s may be indexed", tt.path)
+		}
+	}
+
+	// expired reports are dropped as new ones come in
+	rs.Add("new", nil)
+	if _, ok := rs.reports[expiredID]; ok {
+		t.Error("expired report kept")
+	}
+}
+
+func TestSendLong(t *testing.T) {
+	withQueues(t)
+	oldReports, oldPager := reports, pager
+	pager = &Pager{pending: make(map[string]*pagedReply)}
+	t.Cleanup(func() { reports, pager = oldReports, oldPager })
+
+	lines := strings.Fields("a b c d e")
+	tests := []struct {
+		reports *ReportServer
+		want    string
+	}{
+		// without reports, long replies are paged
+		{nil, "a"},
+		{NewReportServer(ReportsConfig{Listen: "127.0.0.1:9099", Threshold: 5}), "a"},
+		{NewReportServer(ReportsConfig{Listen: "127.0.0.1:9099", Threshold: 4}), "letters (5 lines): http://127.0.0.1:9099/r/"},
+	}
+	for i, tt := range tests {
+		reports = tt.reports
+		sendLong("#pinbot", "letters", lines)
+		sent := queued()
+		if len(sent) == 0 || !strings.HasPrefix(sent[0].message, tt.want) {
+			t.Errorf("%d: sent %v, want %q first", i, sent, tt.want)
+		}
+	}
+}
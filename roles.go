@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// EveryoneRole lists the commands anybody may run, friend or not.
+	EveryoneRole = "everyone"
+
+	AdminRole = "admin"
+	PinRole   = "pin"
+
+	// allCommands grants every command when listed in a role.
+	allCommands = "*"
+)
+
+// Roles maps role names to the commands they grant.
+type Roles map[string][]string
+
+var DefaultRoles = Roles{
+	EveryoneRole: {cmdBotsnack, cmdFriends, cmdStatus, cmdOngoing, cmdMore, cmdPins, cmdWhois, cmdJobs, cmdExpiring, cmdHistory},
+	"viewer":     {cmdBotsnack, cmdFriends, cmdStatus, cmdOngoing, cmdMore, cmdPins, cmdWhois, cmdJobs, cmdExpiring, cmdHistory},
+	"pinner":     {cmdPin, cmdPinLegacy, cmdCancel, cmdExtend, cmdRollback},
+	"unpinner":   {cmdUnPin, cmdUnpinLegacy, cmdCancel},
+	"recoverer":  {cmdRecover, cmdCancel},
+	PinRole:      {cmdPin, cmdUnPin, cmdRecover, cmdPinLegacy, cmdUnpinLegacy, cmdCancel, cmdExtend, cmdRollback},
+	AdminRole:    {allCommands},
+}
+
+var (
+	rolesMu sync.RWMutex
+	roles   = DefaultRoles
+)
+
+// currentRoles returns the roles in effect.
+func currentRoles() Roles {
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+	return roles
+}
+
+// setRoles replaces the roles in effect.
+func setRoles(r Roles) {
+	rolesMu.Lock()
+	roles = r
+	rolesMu.Unlock()
+}
+
+// commands lists every command that roles may grant.
+func commands() []string {
+	return []string{
+		cmdBotsnack,
+		cmdFriends,
+		cmdBefriend,
+		cmdShun,
+		cmdPin,
+		cmdUnPin,
+		cmdStatus,
+		cmdOngoing,
+		cmdMore,
+		cmdRecover,
+		cmdPinLegacy,
+		cmdUnpinLegacy,
+		cmdPins,
+		cmdWhois,
+		cmdJobs,
+		cmdCancel,
+		cmdReload,
+		cmdExtend,
+		cmdExpiring,
+		cmdHistory,
+		cmdRollback,
+	}
+}
+
+// Allows reports whether role grants cmd.
+func (r Roles) Allows(role, cmd string) bool {
+	for _, c := range r[role] {
+		if c == cmd || c == allCommands {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid reports whether friends may be given role.
+func (r Roles) Valid(role string) bool {
+	_, ok := r[role]
+	return ok && role != EveryoneRole
+}
+
+// LoadRoles returns the roles set in cfg, or else those in the roles file,
+// or else the default roles if there is no roles file.
+func LoadRoles(cfg *Config) (Roles, error) {
+	if cfg.Roles != nil {
+		return cfg.Roles, nil
+	}
+	buf, err := os.ReadFile(cfg.Files.Roles)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return DefaultRoles, nil
+		}
+		return nil, err
+	}
+	return ParseRoles(buf)
+}
+
+// ParseRoles reads roles, one per line, in the form
+//
+//	<role> <command> [<command>...]
+//
+// where a command of "*" grants every command.
+func ParseRoles(buf []byte) (Roles, error) {
+	r := make(Roles)
+	for _, l := range bytes.Split(buf, []byte("\n")) {
+		parts := strings.Fields(string(l))
+		if len(parts) == 0 {
+			continue
+		}
+		if len(parts) < 2 {
+			return r, fmt.Errorf("format error. role %s grants no commands", parts[0])
+		}
+
+		role := parts[0]
+		for _, cmd := range parts[1:] {
+			if !validCommand(cmd) {
+				return r, fmt.Errorf("role %s: unknown command: %s", role, cmd)
+			}
+		}
+		r[role] = parts[1:]
+	}
+	return r, nil
+}
+
+func validCommand(cmd string) bool {
+	if cmd == allCommands {
+		return true
+	}
+	for _, c := range commands() {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
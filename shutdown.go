@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout bounds how long a shutdown waits for running commands,
+// queued messages and the QUIT to go through.
+var shutdownTimeout = 30 * time.Second
+
+// Exit codes. A panic exits with 2.
+const (
+	exitOK = 0
+	// exitConfig means the configuration is invalid.
+	exitConfig = 1
+	// exitUnclean means the shutdown deadline passed or state could not
+	// be saved, so some messages or reports may have been lost.
+	exitUnclean = 3
+)
+
+var (
+	shuttingDown atomic.Bool
+	connected    atomic.Bool
+	// disconnected is closed once the bot has left IRC during a shutdown.
+	disconnected = make(chan struct{})
+)
+
+// connectionLost is called whenever the bot disconnects. During a shutdown
+// it never returns.
+func connectionLost() {
+	if shuttingDown.Load() {
+		close(disconnected)
+		select {}
+	}
+	reconnectsTotal.Inc()
+	logger.Warn("connection lost, reconnecting")
+}
+
+// exitOnSignal shuts down on SIGTERM or an interrupt. A second signal exits
+// right away.
+func exitOnSignal(channel string) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+
+	sig := <-sigs
+	logger.Info("shutting down", "signal", sig)
+	done := make(chan int, 1)
+	go func() {
+		done <- shutdown(channel)
+	}()
+
+	select {
+	case code := <-done:
+		os.Exit(code)
+	case sig := <-sigs:
+		logger.Warn("exiting now", "signal", sig)
+		os.Exit(exitUnclean)
+	}
+}
+
+// shutdown stops taking commands, lets running ones finish, saves the jobs,
+// delivers the queued messages and leaves IRC, all within shutdownTimeout.
+// It returns the exit code.
+func shutdown(channel string) int {
+	shuttingDown.Store(true)
+	deadline := time.Now().Add(shutdownTimeout)
+	code := exitOK
+
+	msg := "going down, back soon"
+	if dropped := pool.Stop(); dropped > 0 {
+		msg += fmt.Sprintf(" (dropped %d queued commands, please try them again later)", dropped)
+	}
+	select {
+	case msgs <- msgWrap{actor: channel, message: msg}:
+	default:
+	}
+
+	if !pool.Wait(deadline) {
+		logger.Warn("shutdown: commands still running, they will be reported as interrupted")
+		code = exitUnclean
+	}
+
+	if err := jobs.Checkpoint(); err != nil {
+		logger.Error("shutdown: failed to save jobs", "err", err)
+		code = exitUnclean
+	}
+
+	if !connected.Load() {
+		if n := len(msgs) + outq.Depth(); n > 0 {
+			logger.Warn("shutdown: not connected, dropping messages", "count", n)
+			code = exitUnclean
+		}
+		return code
+	}
+
+	if !flushMessages(deadline) {
+		logger.Warn("shutdown: timed out delivering messages")
+		return exitUnclean
+	}
+
+	go bot.Send("QUIT :going down")
+	select {
+	case <-disconnected:
+	case <-time.After(time.Until(deadline)):
+		logger.Warn("shutdown: timed out waiting for the server to close the connection")
+		code = exitUnclean
+	}
+	return code
+}
+
+// flushMessages waits until every message queued so far has been handed to
+// the bot, or until deadline. It reports whether they all were.
+func flushMessages(deadline time.Time) bool {
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+
+	flushed := make(chan struct{})
+	select {
+	case msgs <- msgWrap{flushed: flushed}:
+	case <-timeout.C:
+		return false
+	}
+
+	select {
+	case <-flushed:
+		return true
+	case <-timeout.C:
+		return false
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Replier sends pinbot's messages out on a chat network.
+type Replier interface {
+	// Msg sends msg to a channel or user.
+	Msg(to, msg string)
+	// Notice sends msg to a channel or user without inviting a reply.
+	Notice(to, msg string)
+}
+
+// Transport is a chat network that pinbot takes commands from and replies
+// on.
+type Transport interface {
+	Replier
+	// Name identifies the transport in addresses (see address).
+	Name() string
+}
+
+// Command is a chat message addressed to pinbot, whatever network it came
+// from.
+type Command interface {
+	// Transport is the network the command came in on.
+	Transport() Transport
+	// Sender identifies who sent the command.
+	Sender() Sender
+	// Channel is the channel or user that replies go to.
+	Channel() string
+	// Private is where replies meant for the sender alone go.
+	Private() string
+	// Text is the whole message, including the command prefix.
+	Text() string
+}
+
+// defaultTransport is the name of the transport whose channels are
+// addressed without a prefix, so that addresses from before there were
+// several transports (in jobs.json, say) keep working.
+const defaultTransport = "irc"
+
+var (
+	transportsMu sync.RWMutex
+	transports   = make(map[string]Transport)
+)
+
+// RegisterTransport makes t available for delivering messages.
+func RegisterTransport(t Transport) {
+	transportsMu.Lock()
+	transports[t.Name()] = t
+	transportsMu.Unlock()
+}
+
+// address returns the name that messages for channel on t are queued
+// under: "<transport>:<channel>", or just the channel for the default
+// transport.
+func address(t Transport, channel string) string {
+	if t.Name() == defaultTransport {
+		return channel
+	}
+	return t.Name() + ":" + channel
+}
+
+// resolve splits an address into its transport and channel.
+func resolve(addr string) (Transport, string, error) {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+
+	if name, channel, ok := strings.Cut(addr, ":"); ok {
+		if t, ok := transports[name]; ok {
+			return t, channel, nil
+		}
+	}
+	t, ok := transports[defaultTransport]
+	if !ok {
+		return nil, "", fmt.Errorf("no transport for %s", addr)
+	}
+	return t, addr, nil
+}
+
+// actorOf returns the address that queued replies to c go to.
+func actorOf(c Command) string {
+	return address(c.Transport(), c.Channel())
+}
+
+// reply queues msg for where c came from.
+func reply(c Command, msg string) {
+	botMsg(actorOf(c), msg)
+}
+
+// Handle runs the command c invokes, if any, and if its sender is allowed
+// to. It reports whether c was a command pinbot handled.
+func Handle(c Command) bool {
+	if !strings.HasPrefix(c.Text(), prefix) {
+		return false
+	}
+	if shuttingDown.Load() {
+		return true
+	}
+
+	args := strings.Fields(strings.TrimPrefix(c.Text(), prefix))
+	if len(args) == 0 {
+		return false
+	}
+	run, ok := handlers[args[0]]
+	if !ok {
+		return false
+	}
+	l := commandLogger(c, args)
+	if !friends.Can(c.Sender(), args[0]) {
+		l.Info("command denied")
+		commandsTotal.Inc(args[0], "denied")
+		return false
+	}
+
+	l.Info("command", "args", strings.Join(args[1:], " "))
+	tc := &trackedCommand{Command: c, name: args[0], dispatch: "accepted", outcome: "ok"}
+	run(tc, args)
+	if tc.dispatch != "accepted" {
+		l.Warn("command not run", "dispatch", tc.dispatch)
+	} else if !tc.dispatched {
+		tc.done()
+	}
+	commandsTotal.Inc(args[0], tc.dispatch)
+	return true
+}
+
+// commandLogger returns a logger carrying the context of command c, whose
+// arguments are args.
+func commandLogger(c Command, args []string) log.Logger {
+	l := logger.New("actor", c.Sender().Nick, "channel", actorOf(c), "cmd", args[0])
+	if account := c.Sender().account(); account != "" {
+		l = l.New("account", account)
+	}
+	if c := findCid(strings.Join(args[1:], " ")); c != "" {
+		l = l.New("cid", c)
+	}
+	return l
+}
+
+// trackedCommand lets dispatch record whether a command got to run, and its
+// handler how it went, for the metrics.
+type trackedCommand struct {
+	Command
+	name     string
+	dispatch string
+	// dispatched is set when the command goes on to run on the worker
+	// pool, which then reports the outcome.
+	dispatched bool
+	outcome    string
+}
+
+// done counts the outcome of the command.
+func (tc *trackedCommand) done() {
+	commandOutcomesTotal.Inc(tc.name, tc.outcome)
+}
+
+// setOutcome records how command c went, if it is being tracked.
+func setOutcome(c Command, outcome string) {
+	if tc, ok := c.(*trackedCommand); ok {
+		tc.outcome = outcome
+	}
+}
+
+// usage tells the sender of c how to use the command, which they got wrong.
+func usage(c Command, msg string) {
+	setOutcome(c, "usage")
+	reply(c, msg)
+}
+
+// refuse tells the sender of c that they may not do what they asked.
+func refuse(c Command, msg string) {
+	setOutcome(c, "refused")
+	reply(c, msg)
+}
+
+// fail tells the sender of c that their command failed.
+func fail(c Command, msg string) {
+	setOutcome(c, "failed")
+	reply(c, msg)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Webhook events.
+const (
+	EventPinRequested     = "pin-requested"
+	EventPinCompleted     = "pin-completed"
+	EventPinFailed        = "pin-failed"
+	EventUnpinCompleted   = "unpin-completed"
+	EventRecoverTriggered = "recover-triggered"
+)
+
+var webhookEvents = []string{
+	EventPinRequested,
+	EventPinCompleted,
+	EventPinFailed,
+	EventUnpinCompleted,
+	EventRecoverTriggered,
+}
+
+var (
+	// webhookRetries is how many times a delivery is attempted before it
+	// is dropped. With the backoff below that spans about a day.
+	webhookRetries = 30
+	webhookBackoff = 10 * time.Second
+	webhookMaxWait = time.Hour
+)
+
+// eventOf returns the webhook event a journal record stands for, if any.
+func eventOf(e *JournalEntry) string {
+	switch {
+	case e.Op == OpPin && e.Result == ResultSubmitted:
+		return EventPinRequested
+	case e.Op == OpPin && e.Result == "pinned":
+		return EventPinCompleted
+	case e.Op == OpPin && (e.Result == ResultFailed || e.Result == ResultTimeout):
+		return EventPinFailed
+	case e.Op == OpUnpin && e.Result == "unpinned":
+		return EventUnpinCompleted
+	case e.Op == OpRecover && e.Result == ResultSubmitted:
+		return EventRecoverTriggered
+	}
+	return ""
+}
+
+// WebhookPayload is the JSON body posted to webhook targets.
+type WebhookPayload struct {
+	ID    string       `json:"id"`
+	Event string       `json:"event"`
+	Time  time.Time    `json:"time"`
+	Entry JournalEntry `json:"entry"`
+}
+
+// Delivery is a payload waiting to be posted to one target.
+type Delivery struct {
+	ID       string          `json:"id"`
+	URL      string          `json:"url"`
+	Event    string          `json:"event"`
+	Body     json.RawMessage `json:"body"`
+	Attempts int             `json:"attempts"`
+	NextTry  time.Time       `json:"next_try"`
+	LastErr  string          `json:"last_error,omitempty"`
+}
+
+// Outbox holds webhook deliveries until their target accepts them, keeping
+// them on disk in the meantime so that a restart does not lose any.
+type Outbox struct {
+	mu      sync.Mutex
+	file    string
+	targets []WebhookConfig
+	pending []*Delivery
+	wake    chan struct{}
+	client  *http.Client
+}
+
+var outbox = NewOutbox("outbox.json")
+
+func NewOutbox(file string) *Outbox {
+	return &Outbox{
+		file:   file,
+		wake:   make(chan struct{}, 1),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SetTargets replaces the webhook targets. Pending deliveries to targets
+// that are gone are dropped when they come up.
+func (o *Outbox) SetTargets(targets []WebhookConfig) {
+	o.mu.Lock()
+	o.targets = targets
+	o.mu.Unlock()
+}
+
+// Load reads the deliveries the last run had not got through yet.
+func (o *Outbox) Load() error {
+	var pending []*Delivery
+	if ok, err := readJSONFile(o.file, &pending); !ok {
+		return err
+	}
+	o.mu.Lock()
+	o.pending = pending
+	o.mu.Unlock()
+	return nil
+}
+
+// save writes out the deliveries still waiting for their targets. It must
+// be called with mu held.
+func (o *Outbox) save() error {
+	return writeJSONFileAtomic(o.file, o.pending)
+}
+
+// Notify queues the event for journal record e, if it is one, for every
+// target that wants it.
+func (o *Outbox) Notify(e *JournalEntry) error {
+	event := eventOf(e)
+	if event == "" {
+		return nil
+	}
+
+	id := newEntryID()
+	body, err := json.Marshal(WebhookPayload{
+		ID:    id,
+		Event: event,
+		Time:  time.Now().UTC(),
+		Entry: *e,
+	})
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	queued := false
+	for _, t := range o.targets {
+		if len(t.Events) > 0 && !slices.Contains(t.Events, event) {
+			continue
+		}
+		o.pending = append(o.pending, &Delivery{
+			ID:      id,
+			URL:     t.URL,
+			Event:   event,
+			Body:    body,
+			NextTry: time.Now(),
+		})
+		queued = true
+	}
+	if !queued {
+		return nil
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return o.save()
+}
+
+// Run delivers pending payloads as they come due.
+func (o *Outbox) Run() {
+	for {
+		wait := o.deliverDue()
+		select {
+		case <-o.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// deliverDue attempts every delivery that is due and returns how long until
+// the next one is.
+func (o *Outbox) deliverDue() time.Duration {
+	o.mu.Lock()
+	now := time.Now()
+	var due []*Delivery
+	for _, d := range o.pending {
+		if !d.NextTry.After(now) {
+			due = append(due, d)
+		}
+	}
+	o.mu.Unlock()
+
+	for _, d := range due {
+		o.attempt(d)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	wait := webhookMaxWait
+	for _, d := range o.pending {
+		wait = min(wait, max(time.Until(d.NextTry), 0))
+	}
+	return wait
+}
+
+// attempt posts d once and reschedules or forgets it.
+func (o *Outbox) attempt(d *Delivery) {
+	o.mu.Lock()
+	var target *WebhookConfig
+	for i := range o.targets {
+		if o.targets[i].URL == d.URL {
+			target = &o.targets[i]
+		}
+	}
+	o.mu.Unlock()
+
+	var err error
+	if target == nil {
+		err = fmt.Errorf("no longer a webhook target")
+	} else {
+		err = o.post(target, d)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	d.Attempts++
+	switch {
+	case err == nil:
+		o.remove(d)
+	case target == nil || d.Attempts >= webhookRetries:
+		logger.Error("webhook: dropping delivery", "event", d.Event, "delivery", d.ID, "url", d.URL, "attempts", d.Attempts, "err", err)
+		o.remove(d)
+	default:
+		logger.Warn("webhook: delivery failed", "event", d.Event, "delivery", d.ID, "url", d.URL, "attempts", d.Attempts, "err", err)
+		d.LastErr = err.Error()
+		d.NextTry = time.Now().Add(min(webhookBackoff<<min(d.Attempts-1, 20), webhookMaxWait))
+	}
+	if err := o.save(); err != nil {
+		logger.Error("webhook: failed to save the outbox", "err", err)
+	}
+}
+
+// remove forgets d. It must be called with mu held.
+func (o *Outbox) remove(d *Delivery) {
+	o.pending = slices.DeleteFunc(o.pending, func(p *Delivery) bool { return p == d })
+}
+
+func (o *Outbox) post(target *WebhookConfig, d *Delivery) error {
+	req, err := http.NewRequest("POST", d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Pinbot-Event", d.Event)
+	req.Header.Set("X-Pinbot-Delivery", d.ID)
+	req.Header.Set("X-Pinbot-Signature", "sha256="+sign(target.Secret, d.Body))
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// sign returns the hex HMAC-SHA256 of body keyed with secret.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOutboxRetry(t *testing.T) {
+	oldRetries := webhookRetries
+	webhookRetries = 4
+	t.Cleanup(func() { webhookRetries = oldRetries })
+
+	var status atomic.Int32
+	var posts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+		if !strings.HasPrefix(r.Header.Get("X-Pinbot-Signature"), "sha256=") {
+			t.Error("delivery is not signed")
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "outbox.json")
+	targets := []WebhookConfig{
+		{URL: srv.URL, Secret: "s3cret"},
+		{URL: srv.URL + "/failures", Secret: "s3cret", Events: []string{EventPinFailed}},
+	}
+	o := NewOutbox(file)
+	o.SetTargets(targets)
+	if err := o.Notify(&JournalEntry{ID: "a", Op: OpPin, Result: "pinned"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		status  int
+		pending bool
+		backoff time.Duration
+	}{
+		{http.StatusInternalServerError, true, webhookBackoff},
+		{http.StatusBadGateway, true, 2 * webhookBackoff},
+		{http.StatusNoContent, false, 0},
+	}
+	for i, tt := range tests {
+		// every attempt is made by an outbox freshly loaded from disk,
+		// as after a restart
+		o = NewOutbox(file)
+		o.SetTargets(targets)
+		if err := o.Load(); err != nil {
+			t.Fatal(err)
+		}
+		if len(o.pending) != 1 {
+			t.Fatalf("%d: %d deliveries loaded, want 1", i, len(o.pending))
+		}
+		d := o.pending[0]
+		if d.URL != srv.URL || d.Event != EventPinCompleted || d.Attempts != i {
+			t.Fatalf("%d: loaded %+v, want attempt %d of pin-completed to %s", i, d, i, srv.URL)
+		}
+		if i > 0 && d.NextTry.Before(time.Now().Add(tests[i-1].backoff/2)) {
+			t.Errorf("%d: loaded next try %s, want the backoff kept", i, d.NextTry)
+		}
+		d.NextTry = time.Now()
+
+		status.Store(int32(tt.status))
+		start := time.Now()
+		o.deliverDue()
+		if len(o.pending) > 0 != tt.pending {
+			t.Fatalf("%d: pending %+v after a %d", i, o.pending, tt.status)
+		}
+		if !tt.pending {
+			continue
+		}
+		if wait := d.NextTry.Sub(start); wait < tt.backoff || wait > tt.backoff+time.Second {
+			t.Errorf("%d: next try in %s, want %s", i, wait, tt.backoff)
+		}
+		if want := fmt.Sprintf("%d %s", tt.status, http.StatusText(tt.status)); d.LastErr != want {
+			t.Errorf("%d: last error %q, want %q", i, d.LastErr, want)
+		}
+	}
+	if n := posts.Load(); n != int32(len(tests)) {
+		t.Errorf("%d posts, want %d", n, len(tests))
+	}
+
+	o = NewOutbox(file)
+	if err := o.Load(); err != nil || len(o.pending) != 0 {
+		t.Errorf("outbox holds %+v, %v after the delivery went through", o.pending, err)
+	}
+}
+
+func TestOutboxDrop(t *testing.T) {
+	oldRetries := webhookRetries
+	webhookRetries = 2
+	t.Cleanup(func() { webhookRetries = oldRetries })
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		targets  []WebhookConfig
+		attempts int
+	}{
+		// given up on after webhookRetries
+		{[]WebhookConfig{{URL: srv.URL, Secret: "s"}}, 2},
+		// dropped at once when the target is gone
+		{nil, 1},
+	}
+	for i, tt := range tests {
+		o := NewOutbox(filepath.Join(t.TempDir(), "outbox.json"))
+		o.SetTargets([]WebhookConfig{{URL: srv.URL, Secret: "s"}})
+		if err := o.Notify(&JournalEntry{ID: "a", Op: OpUnpin, Result: "unpinned"}); err != nil {
+			t.Fatal(err)
+		}
+		o.SetTargets(tt.targets)
+		for n := 1; n <= tt.attempts; n++ {
+			if len(o.pending) != 1 {
+				t.Fatalf("%d: delivery dropped before attempt %d", i, n)
+			}
+			o.pending[0].NextTry = time.Now()
+			o.deliverDue()
+		}
+		if len(o.pending) != 0 {
+			t.Errorf("%d: pending %+v after %d attempts, want it dropped", i, o.pending, tt.attempts)
+		}
+	}
+}
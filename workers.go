@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueFull = errors.New("the work queue is full")
+	ErrStopped   = errors.New("shutting down")
+)
+
+// WorkerPool runs commands on a fixed number of workers. Commands that
+// cannot start right away wait in a bounded queue, which is served
+// round-robin across users so that nobody can hog the pool.
+type WorkerPool struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	workers int
+	max     int
+	queued  int
+	busy    int
+	stopped bool
+	queues  map[string][]func()
+	// order holds the users with queued commands, next to be served first.
+	order []string
+}
+
+var pool *WorkerPool
+
+// NewWorkerPool starts a pool of workers goroutines that queues up to
+// queue commands.
+func NewWorkerPool(workers, queue int) *WorkerPool {
+	p := &WorkerPool{
+		workers: workers,
+		max:     queue,
+		queues:  make(map[string][]func()),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Submit schedules fn to run on behalf of user. It returns 0 when fn is
+// picked up right away, or else its position in the queue.
+func (p *WorkerPool) Submit(user string, fn func()) (int, error) {
+	user = strings.ToLower(user)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return 0, ErrStopped
+	}
+	// commands that idle workers are about to pick up do not wait in
+	// the queue
+	if p.queued >= p.max+p.workers-p.busy {
+		return 0, ErrQueueFull
+	}
+
+	pos := max(p.ahead(user)-(p.workers-p.busy)+1, 0)
+
+	if len(p.queues[user]) == 0 {
+		p.order = append(p.order, user)
+	}
+	p.queues[user] = append(p.queues[user], fn)
+	p.queued++
+	p.cond.Signal()
+	return pos, nil
+}
+
+// ahead returns how many queued commands will be served before a new
+// command from user. It must be called with mu held.
+func (p *WorkerPool) ahead(user string) int {
+	// the new command is served in round k of the rotation, after the
+	// round k commands of the users before user in the rotation.
+	k := len(p.queues[user])
+	r := slices.Index(p.order, user)
+	if r < 0 {
+		r = len(p.order)
+	}
+
+	ahead := k
+	for i, u := range p.order {
+		if u == user {
+			continue
+		}
+		n := len(p.queues[u])
+		ahead += min(n, k)
+		if i < r && n > k {
+			ahead++
+		}
+	}
+	return ahead
+}
+
+// Stop makes the pool refuse new commands and drops the queued ones. It
+// returns how many were dropped.
+func (p *WorkerPool) Stop() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	dropped := p.queued
+	p.stopped = true
+	p.queues = make(map[string][]func())
+	p.order = nil
+	p.queued = 0
+	return dropped
+}
+
+// Wait waits until no command is running, or until deadline. It reports
+// whether the pool went idle.
+func (p *WorkerPool) Wait(deadline time.Time) bool {
+	for {
+		p.mu.Lock()
+		idle := p.busy == 0 && p.queued == 0
+		p.mu.Unlock()
+		if idle {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (p *WorkerPool) work() {
+	for {
+		p.mu.Lock()
+		for p.queued == 0 {
+			p.cond.Wait()
+		}
+
+		user := p.order[0]
+		p.order = p.order[1:]
+		fn := p.queues[user][0]
+		p.queues[user] = p.queues[user][1:]
+		if len(p.queues[user]) > 0 {
+			p.order = append(p.order, user)
+		} else {
+			delete(p.queues, user)
+		}
+		p.queued--
+		p.busy++
+		p.mu.Unlock()
+
+		fn()
+
+		p.mu.Lock()
+		p.busy--
+		p.mu.Unlock()
+	}
+}
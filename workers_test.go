@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolAhead(t *testing.T) {
+	noop := func() {}
+	p := &WorkerPool{
+		queues: map[string][]func(){
+			"a": {noop, noop, noop},
+			"b": {noop},
+		},
+		order: []string{"a", "b"},
+	}
+	tests := []struct {
+		user string
+		want int
+	}{
+		// served a, b, c
+		{"c", 2},
+		// served a, b, a, b
+		{"b", 3},
+		// served a, b, a, a, a
+		{"a", 4},
+	}
+	for _, tt := range tests {
+		if got := p.ahead(tt.user); got != tt.want {
+			t.Errorf("ahead(%q) = %d, want %d", tt.user, got, tt.want)
+		}
+	}
+}
+
+// waitBusy waits until n workers of p are running commands.
+func waitBusy(t *testing.T, p *WorkerPool, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.mu.Lock()
+		busy := p.busy
+		p.mu.Unlock()
+		if busy == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d workers busy, want %d", busy, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWorkerPoolSubmitFair(t *testing.T) {
+	p := NewWorkerPool(1, 10)
+	gate := make(chan struct{})
+	if pos, err := p.Submit("x", func() { <-gate }); err != nil || pos != 0 {
+		t.Fatalf("Submit = %d, %v, want 0, nil", pos, err)
+	}
+	waitBusy(t, p, 1)
+
+	ran := make(chan string, 4)
+	submit := func(user string, want int) {
+		t.Helper()
+		pos, err := p.Submit(user, func() { ran <- user })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pos != want {
+			t.Errorf("Submit(%q) position = %d, want %d", user, pos, want)
+		}
+	}
+	submit("a", 1)
+	submit("A", 2)
+	submit("a", 3)
+	submit("b", 2)
+	close(gate)
+
+	var order []string
+	for range 4 {
+		order = append(order, <-ran)
+	}
+	want := []string{"a", "b", "A", "a"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("ran %v, want %v", order, want)
+		}
+	}
+}
+
+func TestWorkerPoolSubmitFull(t *testing.T) {
+	tests := []struct {
+		workers, queue int
+		// accepted is how many blocking commands fit before the pool
+		// is full.
+		accepted int
+	}{
+		{workers: 1, queue: 0, accepted: 1},
+		{workers: 2, queue: 0, accepted: 2},
+		{workers: 1, queue: 2, accepted: 3},
+	}
+	for _, tt := range tests {
+		p := NewWorkerPool(tt.workers, tt.queue)
+		gate := make(chan struct{})
+		for i := range tt.accepted {
+			if _, err := p.Submit("x", func() { <-gate }); err != nil {
+				t.Fatalf("workers %d, queue %d: command %d: %s", tt.workers, tt.queue, i+1, err)
+			}
+		}
+		if _, err := p.Submit("x", func() {}); err != ErrQueueFull {
+			t.Errorf("workers %d, queue %d: command %d: got %v, want ErrQueueFull",
+				tt.workers, tt.queue, tt.accepted+1, err)
+		}
+		close(gate)
+		if !p.Wait(time.Now().Add(5 * time.Second)) {
+			t.Fatal("pool did not go idle")
+		}
+	}
+}
+
+func TestWorkerPoolStop(t *testing.T) {
+	p := NewWorkerPool(1, 5)
+	gate := make(chan struct{})
+	p.Submit("x", func() { <-gate })
+	waitBusy(t, p, 1)
+	p.Submit("x", func() {})
+	p.Submit("y", func() {})
+
+	if dropped := p.Stop(); dropped != 2 {
+		t.Errorf("Stop dropped %d commands, want 2", dropped)
+	}
+	if _, err := p.Submit("x", func() {}); err != ErrStopped {
+		t.Errorf("Submit after Stop: got %v, want ErrStopped", err)
+	}
+	close(gate)
+	if !p.Wait(time.Now().Add(5 * time.Second)) {
+		t.Error("pool did not go idle")
+	}
+}